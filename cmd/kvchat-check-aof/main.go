@@ -0,0 +1,163 @@
+// kvchat-check-aof verifies an AOF offline, a file or a multi-part
+// directory, and can cut it back to its last valid entry
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/commands"
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// report is what a check found
+type report struct {
+	Preamble     bool
+	PreambleKeys int
+	// Valid commands by name
+	Commands map[string]int
+	Total    int
+	// Commands carrying a timestamp, and the first and last one
+	Annotated   int
+	First, Last time.Time
+	// The file is valid up to here
+	ValidOffset int64
+	// First problem found, nil if the whole file is valid
+	Err error
+}
+
+// checkAOF reads an AOF until the end or the first invalid entry
+func checkAOF(r io.Reader) report {
+	result := report{Commands: map[string]int{}}
+	reader := replay.NewReader(r)
+
+	found, err := reader.ReadPreamble(func(string, store.Value) {
+		result.PreambleKeys++
+	})
+	result.Preamble = found
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	for {
+		result.ValidOffset = reader.Offset()
+		entry, err := reader.ReadEntry()
+		if err == io.EOF {
+			return result
+		}
+		if err == nil {
+			err = validateCommand(entry.Args)
+			if err != nil {
+				err = &replay.CorruptedError{Offset: result.ValidOffset, Err: err}
+			}
+		}
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Commands[strings.ToUpper(string(entry.Args[0]))]++
+		result.Total++
+		if !entry.Time.IsZero() {
+			if result.Annotated == 0 {
+				result.First = entry.Time
+			}
+			result.Last = entry.Time
+			result.Annotated++
+		}
+	}
+}
+
+// validateCommand checks that a command can be replayed, private ones
+// included
+func validateCommand(args [][]byte) error {
+	if len(args) == 0 {
+		return errors.New("empty command")
+	}
+	name := strings.ToUpper(string(args[0]))
+	command, ok := commands.Registry[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if !protocol.CheckArity(len(args), command.Arity) {
+		return fmt.Errorf("wrong number of arguments for %s", name)
+	}
+	return nil
+}
+
+func (r report) print(w io.Writer) {
+	if r.Preamble {
+		fmt.Fprintf(w, "Snapshot preamble: %d keys\n", r.PreambleKeys)
+	}
+	names := make([]string, 0, len(r.Commands))
+	for name := range r.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%-12s %d\n", name, r.Commands[name])
+	}
+	if r.Annotated > 0 {
+		fmt.Fprintf(w, "Annotated: %d, written from %s to %s\n", r.Annotated,
+			r.First.Format(time.RFC3339Nano), r.Last.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(w, "Commands: %d, valid up to offset %d\n", r.Total, r.ValidOffset)
+}
+
+func main() {
+	fix := flag.Bool("fix", false, "Truncate the AOF to its last valid entry")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-fix] <file.aof>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(run(flag.Arg(0), *fix, os.Stdout))
+}
+
+// run checks filename and returns the exit code: 0 if the AOF is valid or
+// was fixed, 1 otherwise
+func run(filename string, fix bool, w io.Writer) int {
+	file, err := persistence.OpenAOF(filename)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to open AOF: %v\n", err)
+		return 1
+	}
+	result := checkAOF(file)
+	size := file.Size()
+	file.Close()
+
+	result.print(w)
+	if result.Err == nil {
+		fmt.Fprintln(w, "AOF is valid")
+		return 0
+	}
+	fmt.Fprintf(w, "AOF is not valid: %v\n", result.Err)
+	if !fix {
+		return 1
+	}
+
+	if result.ValidOffset == 0 && result.Preamble {
+		fmt.Fprintln(w, "The snapshot preamble is damaged, refusing to truncate")
+		return 1
+	}
+	fmt.Fprintf(w, "Discarding %d bytes after offset %d\n", size-result.ValidOffset, result.ValidOffset)
+	if err := persistence.TruncateAOF(filename, result.ValidOffset); err != nil {
+		fmt.Fprintf(w, "Failed to truncate AOF: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(w, "AOF fixed")
+	return 0
+}
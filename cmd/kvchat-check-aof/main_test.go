@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func encode(args ...string) []byte {
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	return protocol.EncodeCommand(command)
+}
+
+func TestCheckAOF_Valid(t *testing.T) {
+	var data bytes.Buffer
+	persistence.WriteSnapshot(&data, persistence.SnapshotHeader{CreatedAt: time.Now()}, map[string]store.Value{
+		"key": {Kind: store.StringType, Str: []byte("value")},
+	})
+	data.Write(encode("SET", "a", "1"))
+	data.Write(encode("SET", "b", "2"))
+	data.Write(encode("RPUSH", "list", "x"))
+	data.Write(encode("EXPIREAT", "a", "4102444800"))
+	size := data.Len()
+
+	result := checkAOF(&data)
+	if result.Err != nil {
+		t.Fatalf("Expected a valid AOF, got %v", result.Err)
+	}
+	if !result.Preamble || result.PreambleKeys != 1 {
+		t.Fatalf("Expected a preamble with 1 key, got %+v", result)
+	}
+	if result.Total != 4 || result.Commands["SET"] != 2 || result.Commands["RPUSH"] != 1 || result.Commands["EXPIREAT"] != 1 {
+		t.Fatalf("Unexpected command counts: %+v", result.Commands)
+	}
+	if result.ValidOffset != int64(size) {
+		t.Fatalf("Expected valid up to %d, got %d", size, result.ValidOffset)
+	}
+}
+
+func TestCheckAOF_Annotated(t *testing.T) {
+	first, last := time.UnixMilli(1700000000000), time.UnixMilli(1700000005000)
+	var data bytes.Buffer
+	data.Write(encode("SET", "a", "1"))
+	data.Write(persistence.Annotate(encode("SET", "b", "2"), first))
+	data.Write(persistence.Annotate(encode("SET", "c", "3"), last))
+
+	result := checkAOF(&data)
+	if result.Err != nil {
+		t.Fatalf("Expected a valid AOF, got %v", result.Err)
+	}
+	if result.Total != 3 || result.Annotated != 2 || !result.First.Equal(first) || !result.Last.Equal(last) {
+		t.Fatalf("Unexpected report %+v", result)
+	}
+}
+
+func TestCheckAOF_Invalid(t *testing.T) {
+	valid := encode("SET", "a", "1")
+	tests := []struct {
+		name string
+		bad  []byte
+	}{
+		{name: "unknown command", bad: encode("FLUSHALL")},
+		{name: "wrong arity", bad: encode("SET", "a")},
+		{name: "malformed", bad: []byte("*2\r\n$x\r\n")},
+		{name: "truncated", bad: encode("SET", "b", "2")[:10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(bytes.Clone(valid), tt.bad...)
+			data = append(data, valid...)
+			result := checkAOF(bytes.NewReader(data))
+			if result.Err == nil {
+				t.Fatal("Expected an error")
+			}
+			if result.ValidOffset != int64(len(valid)) || result.Total != 1 {
+				t.Fatalf("Expected 1 valid command up to %d, got %d up to %d", len(valid), result.Total, result.ValidOffset)
+			}
+			var truncated *replay.TruncatedError
+			var corrupted *replay.CorruptedError
+			if !errors.As(result.Err, &truncated) && !errors.As(result.Err, &corrupted) {
+				t.Fatalf("Expected the error to carry an offset, got %v", result.Err)
+			}
+		})
+	}
+}
+
+func TestRun_Fix(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	valid := encode("SET", "a", "1")
+	os.WriteFile(filename, append(bytes.Clone(valid), "*3\r\n$3\r\nSET"...), 0644)
+
+	var output bytes.Buffer
+	if code := run(filename, false, &output); code != 1 {
+		t.Fatalf("Expected exit code 1, got %d:\n%s", code, output.String())
+	}
+	if !strings.Contains(output.String(), "offset 27") {
+		t.Fatalf("Expected the offset in the output:\n%s", output.String())
+	}
+
+	output.Reset()
+	if code := run(filename, true, &output); code != 0 {
+		t.Fatalf("Expected exit code 0 after fixing, got %d:\n%s", code, output.String())
+	}
+	if data, _ := os.ReadFile(filename); !bytes.Equal(data, valid) {
+		t.Fatalf("Expected the AOF to be cut back to the valid entry, got %q", data)
+	}
+
+	output.Reset()
+	if code := run(filename, false, &output); code != 0 || !strings.Contains(output.String(), "AOF is valid") {
+		t.Fatalf("Expected the fixed AOF to be valid, got %d:\n%s", code, output.String())
+	}
+}
+
+func TestRun_FixMultiPart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "aof")
+	aof := persistence.NewMultiPartAOF(dir, persistence.FsyncEverySec)
+	aof.SetMaxPartSize(1)
+	aof.Append(encode("SET", "a", "1"))
+	aof.Append(encode("SET", "b", "2"))
+	aof.Close()
+	manifest, _ := persistence.ReadManifest(dir)
+	last := filepath.Join(dir, manifest.Incrs[len(manifest.Incrs)-1])
+	os.WriteFile(last, []byte("*3\r\n$3\r\nSET"), 0644)
+
+	var output bytes.Buffer
+	if code := run(dir, true, &output); code != 0 {
+		t.Fatalf("Expected exit code 0 after fixing, got %d:\n%s", code, output.String())
+	}
+	if !strings.Contains(output.String(), "Commands: 2") {
+		t.Fatalf("Expected the commands of every part to be counted:\n%s", output.String())
+	}
+	if data, _ := os.ReadFile(last); len(data) != 0 {
+		t.Fatalf("Expected the last part to be cut back, got %q", data)
+	}
+}
@@ -0,0 +1,117 @@
+// kvchat-recover-aof restores the state an AOF, a file or a multi-part
+// directory, held at an earlier point in time, as a truncated copy of the
+// AOF or as a snapshot
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func main() {
+	at := flag.String("time", "", "Recover the state as of this time, RFC 3339 or unix milliseconds")
+	offset := flag.Int64("offset", 0, "Recover the state as of this AOF offset")
+	output := flag.String("output", "", "Write the AOF cut off at the target here")
+	snapshot := flag.String("snapshot", "", "Write a snapshot of the recovered state here")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -time T|-offset N [-output file.aof] [-snapshot file.snap] <file.aof>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	target, err := replay.ParseTarget(*at, *offset)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flag.NArg() != 1 || target.IsZero() || (*output == "" && *snapshot == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(run(flag.Arg(0), target, *output, *snapshot, os.Stdout))
+}
+
+// run recovers filename up to target into output and snapshot, either may
+// be empty, and returns the exit code
+func run(filename string, target replay.Target, output, snapshot string, w io.Writer) int {
+	file, err := persistence.OpenAOF(filename)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to open AOF: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	storage := store.NewStorage()
+	reader := replay.NewReader(file)
+	if _, err := reader.ReadPreamble(storage.Restore); err != nil {
+		fmt.Fprintf(w, "Failed to read AOF: %v\n", err)
+		return 1
+	}
+	recovered, err := reader.ApplyUntil(storage, target)
+	var truncated *replay.TruncatedError
+	if errors.As(err, &truncated) {
+		fmt.Fprintf(w, "Warning: %v, recovering up to there\n", err)
+		err = nil
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Failed to recover: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(w, "Recovered %d commands up to offset %d\n", recovered.Commands, recovered.Offset)
+	if !recovered.Last.IsZero() {
+		fmt.Fprintf(w, "Last command written at %s\n", recovered.Last.Format(time.RFC3339Nano))
+	}
+
+	if output != "" {
+		if err := writePrefix(output, filename, recovered.Offset); err != nil {
+			fmt.Fprintf(w, "Failed to write AOF: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(w, "Wrote AOF %s\n", output)
+	}
+	if snapshot != "" {
+		header := persistence.SnapshotHeader{CreatedAt: time.Now()}
+		if err := persistence.SaveSnapshot(snapshot, header, storage.Snapshot()); err != nil {
+			fmt.Fprintf(w, "Failed to write snapshot: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(w, "Wrote snapshot %s with %d keys\n", snapshot, storage.Stats().Keys)
+	}
+	return 0
+}
+
+// writePrefix writes the first size bytes of the AOF at path to filename,
+// replacing it only once they are all on disk. A multi-part AOF comes out
+// as a single file.
+func writePrefix(filename, path string, size int64) error {
+	src, err := persistence.OpenAOF(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := filename + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(dst, src, size)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
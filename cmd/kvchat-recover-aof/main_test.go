@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func encode(args ...string) []byte {
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	return protocol.EncodeCommand(command)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.aof")
+	start := time.UnixMilli(1700000000000)
+	var data bytes.Buffer
+	data.Write(persistence.Annotate(encode("RPUSH", "chat", "hello"), start))
+	data.Write(persistence.Annotate(encode("RPUSH", "chat", "world"), start.Add(time.Minute)))
+	kept := data.Len()
+	data.Write(persistence.Annotate(encode("DEL", "chat"), start.Add(10*time.Minute)))
+	os.WriteFile(filename, data.Bytes(), 0644)
+
+	output := filepath.Join(dir, "recovered.aof")
+	snapshot := filepath.Join(dir, "recovered.snap")
+	var out bytes.Buffer
+	if code := run(filename, replay.Target{Time: start.Add(5 * time.Minute)}, output, snapshot, &out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "Recovered 2 commands") {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+
+	if recovered, _ := os.ReadFile(output); !bytes.Equal(recovered, data.Bytes()[:kept]) {
+		t.Fatalf("Expected the AOF cut off at %d bytes, got %q", kept, recovered)
+	}
+	if original, _ := os.ReadFile(filename); !bytes.Equal(original, data.Bytes()) {
+		t.Fatal("Expected the original AOF to be left alone")
+	}
+	storage := store.NewStorage()
+	if _, err := persistence.LoadSnapshot(snapshot, storage.Restore); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if list, _ := storage.LRange("chat", 0, -1); len(list) != 2 {
+		t.Fatalf("Expected both messages in the snapshot, got %q", list)
+	}
+}
+
+func TestRun_Corrupted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	os.WriteFile(filename, []byte("*2\r\n$x\r\n"), 0644)
+
+	var out bytes.Buffer
+	output := filepath.Join(t.TempDir(), "recovered.aof")
+	if code := run(filename, replay.Target{Time: time.Now()}, output, "", &out); code != 1 {
+		t.Fatalf("Expected exit code 1, got %d", code)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatal("Expected no output for a corrupted AOF")
+	}
+}
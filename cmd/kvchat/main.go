@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
-	"io"
+	"fmt"
+	"io/fs"
 	"log"
 	"net"
 	"os"
@@ -14,9 +16,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/flash10042/kv-chat/internal/acl"
+	"github.com/flash10042/kv-chat/internal/metrics"
 	"github.com/flash10042/kv-chat/internal/persistence"
-	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/replay"
 	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/slowlog"
 	"github.com/flash10042/kv-chat/internal/store"
 )
 
@@ -25,38 +30,331 @@ var Version = "0.1.0"
 type Config struct {
 	Address string `json:"address"`
 	AOFPath string `json:"aof_path"`
+	// When the AOF is fsynced: always, everysec (default) or no
+	AppendFsync string `json:"appendfsync"`
+	// The AOF is rewritten once it has grown by this percentage since the
+	// last rewrite, default 100 and 0 disables it, and is at least
+	// auto_aof_rewrite_min_size bytes, default 64MB
+	AutoAOFRewritePercentage *int  `json:"auto_aof_rewrite_percentage"`
+	AutoAOFRewriteMinSize    int64 `json:"auto_aof_rewrite_min_size"`
+	// Rewrites start the AOF with a binary snapshot, default true
+	AOFUseSnapshotPreamble *bool `json:"aof_use_snapshot_preamble"`
+	// Cut off an incomplete command at the end of the AOF on start instead
+	// of refusing to start, default true. Corruption elsewhere is always
+	// an error.
+	AOFLoadTruncated *bool `json:"aof_load_truncated"`
+	// Precede every AOF entry with the time it was written and a checksum
+	// that replay verifies, default false
+	AOFAnnotations bool `json:"aof_annotations"`
+	// Keep the AOF as a directory with a manifest, a base file written by
+	// rewrites and incremental files, moving on to a new one every
+	// aof_max_part_size bytes if set. An existing directory is always read
+	// this way.
+	AOFMultiPart   bool  `json:"aof_multi_part"`
+	AOFMaxPartSize int64 `json:"aof_max_part_size"`
+
+	// Binary snapshot written by SAVE, BGSAVE and the save rules, and
+	// loaded on start with the AOF replayed on top. Empty disables it.
+	SnapshotPath string     `json:"snapshot_path"`
+	Save         []SaveRule `json:"save"`
+
+	// Point-in-time recovery, replays the AOF only up to here and cuts it
+	// off. Set by flags alone, as it must not run again on every start.
+	RecoverTo replay.Target `json:"-"`
+
+	// Protocol input limits, zero keeps the default
+	ProtoMaxArrayLen       int `json:"proto_max_array_len"`
+	ProtoMaxBulkLen        int `json:"proto_max_bulk_len"`
+	ProtoMaxInlineLen      int `json:"proto_max_inline_len"`
+	ClientQueryBufferLimit int `json:"client_query_buffer_limit"`
+
+	// Password of the default user, empty means no AUTH is needed
+	RequirePass string `json:"requirepass"`
+	// ACL users file, loaded on start and rewritten on ACL changes
+	ACLFile string `json:"aclfile"`
+
+	// TLS listener, served alongside the plaintext one unless that's disabled.
+	// Certificates are reloaded on SIGHUP.
+	TLSAddress       string `json:"tls_address"`
+	TLSCertFile      string `json:"tls_cert_file"`
+	TLSKeyFile       string `json:"tls_key_file"`
+	TLSCAFile        string `json:"tls_ca_file"`
+	TLSClientAuth    string `json:"tls_client_auth"` // none, optional or require
+	TLSMinVersion    string `json:"tls_min_version"` // 1.2 or 1.3
+	DisablePlaintext bool   `json:"disable_plaintext"`
+
+	// Unix socket listener for clients on the same host
+	UnixSocket     string `json:"unix_socket"`
+	UnixSocketPerm string `json:"unix_socket_perm"` // octal, default 0700
+
+	// Connection limits, zero keeps the default
+	MaxClients              int `json:"maxclients"`
+	Timeout                 int `json:"timeout"`       // idle seconds, default never
+	TCPKeepAlive            int `json:"tcp_keepalive"` // seconds, default 300, negative disables
+	ClientOutputBufferLimit int `json:"client_output_buffer_limit"`
+
+	// Commands running for at least this many microseconds go to the
+	// SLOWLOG, zero keeps the default of 10000 and negative disables it
+	SlowlogLogSlowerThan int `json:"slowlog_log_slower_than"`
+	SlowlogMaxLen        int `json:"slowlog_max_len"` // default 128
+
+	// HTTP address serving Prometheus metrics on /metrics, empty disables it
+	MetricsAddress string `json:"metrics_address"`
+
+	// Seconds to wait for in-flight commands on shutdown, default 30
+	ShutdownTimeout int `json:"shutdown_timeout"`
 }
 
+const (
+	defaultTCPKeepAlive    = 300 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+)
+
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	config := loadConfig()
+	if config.AOFPath != "" && config.AOFMultiPart {
+		// An AOF from before multi-part was enabled becomes the first base
+		if err := persistence.MigrateToMultiPart(config.AOFPath); err != nil {
+			log.Fatalf("Failed to convert the AOF to multi-part: %v", err)
+		}
+	}
 
-	storage := store.NewStorage()
+	storage, err := loadData(config)
+	if err != nil {
+		log.Fatalf("Failed to load data: %v", err)
+	}
 
 	var aof *persistence.AOF
 	if config.AOFPath != "" {
-		err := replayAOF(storage, config.AOFPath)
+		policy, err := persistence.ParseFsyncPolicy(config.AppendFsync)
 		if err != nil {
-			log.Fatalf("Failed to replay AOF: %v", err)
+			log.Fatalf("Failed to configure AOF: %v", err)
+		}
+		if config.AOFMultiPart || persistence.IsMultiPart(config.AOFPath) {
+			aof = persistence.NewMultiPartAOF(config.AOFPath, policy)
+			aof.SetMaxPartSize(config.AOFMaxPartSize)
+		} else {
+			aof = persistence.NewAOFWithPolicy(config.AOFPath, policy)
+		}
+		aof.SetSnapshotPreamble(config.AOFUseSnapshotPreamble == nil || *config.AOFUseSnapshotPreamble)
+		aof.SetAnnotations(config.AOFAnnotations)
+		log.Printf("AOF enabled: %s (appendfsync %s)", config.AOFPath, policy)
+		// Data loaded from a snapshot alone must end up in the AOF too
+		if size, _ := aof.Size(); size == 0 && storage.Stats().Keys > 0 {
+			if err := server.RewriteAOF(aof, storage); err != nil {
+				log.Fatalf("Failed to write the loaded data to the AOF: %v", err)
+			}
+		}
+		if rewritePolicy := autoRewriteConfig(config); rewritePolicy.percentage > 0 {
+			go autoRewriteAOF(ctx, aof, storage, rewritePolicy, autoRewriteInterval)
 		}
-
-		aof = persistence.NewAOF(config.AOFPath)
-		defer aof.Close()
-		log.Printf("AOF enabled: %s", config.AOFPath)
 	} else {
 		log.Printf("AOF disabled")
 	}
 
-	startServer(ctx, storage, aof, config.Address)
+	options, err := serverOptions(config)
+	if err != nil {
+		log.Fatalf("Failed to configure server: %v", err)
+	}
+	if config.SnapshotPath != "" {
+		options.Snapshots = server.NewSnapshots(config.SnapshotPath, storage.Changes())
+		// The old snapshot may hold changes the recovery undid
+		if !config.RecoverTo.IsZero() {
+			if err := options.Snapshots.Save(storage, aof); err != nil {
+				log.Fatalf("Failed to save the recovered data: %v", err)
+			}
+		}
+		if len(config.Save) > 0 {
+			go saveOnRules(ctx, options.Snapshots, storage, aof, config.Save, saveRulesInterval)
+		}
+	}
+
+	var reloader *tlsReloader
+	if config.TLSAddress != "" {
+		reloader, err = newTLSReloader(config)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		go reloadOnSIGHUP(ctx, reloader)
+	}
+
+	listeners, err := openListeners(config, reloader)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+
+	if config.MetricsAddress != "" {
+		listener, err := net.Listen("tcp", config.MetricsAddress)
+		if err != nil {
+			log.Fatalf("Failed to listen for metrics: %v", err)
+		}
+		go serveMetrics(ctx, listener, metricsHandler(metrics.Default, serverMetrics(storage, aof, options)))
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if config.ShutdownTimeout > 0 {
+		shutdownTimeout = time.Duration(config.ShutdownTimeout) * time.Second
+	}
+
+	startServer(ctx, storage, aof, listeners, options, shutdownTimeout)
+
+	if options.Snapshots != nil && len(config.Save) > 0 {
+		if err := saveOnShutdown(options.Snapshots, storage, aof); err != nil {
+			log.Printf("Failed to save snapshot on shutdown: %v", err)
+		} else {
+			log.Printf("Snapshot saved")
+		}
+	}
+
+	// Connections are done writing by now, make the last writes durable
+	if aof != nil {
+		if err := aof.Close(); err != nil {
+			log.Printf("Failed to sync AOF on shutdown: %v", err)
+		} else {
+			log.Printf("AOF synced")
+		}
+	}
+}
+
+func reloadOnSIGHUP(ctx context.Context, reloader *tlsReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := reloader.Reload(); err != nil {
+				log.Printf("Failed to reload TLS certificates, keeping the old ones: %v", err)
+				continue
+			}
+			log.Printf("TLS certificates reloaded")
+		}
+	}
+}
+
+// tcpListenConfig applies the keepalive settings to accepted connections
+func tcpListenConfig(config *Config) net.ListenConfig {
+	if config.TCPKeepAlive < 0 {
+		return net.ListenConfig{KeepAlive: -1}
+	}
+	idle := defaultTCPKeepAlive
+	if config.TCPKeepAlive > 0 {
+		idle = time.Duration(config.TCPKeepAlive) * time.Second
+	}
+	return net.ListenConfig{
+		KeepAliveConfig: net.KeepAliveConfig{Enable: true, Idle: idle, Interval: idle / 3},
+	}
+}
+
+// openListeners opens every configured listener, closing the ones already
+// open if any of them fails
+func openListeners(config *Config, reloader *tlsReloader) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	listenConfig := tcpListenConfig(config)
+
+	if !config.DisablePlaintext {
+		listener, err := listenConfig.Listen(context.Background(), "tcp", config.Address)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if reloader != nil {
+		listener, err := listenConfig.Listen(context.Background(), "tcp", config.TLSAddress)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, tls.NewListener(listener, reloader.TLSConfig()))
+	}
+
+	if config.UnixSocket != "" {
+		perm, err := parseSocketPerm(config.UnixSocketPerm)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listener, err := listenUnix(config.UnixSocket, perm)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listeners configured")
+	}
+	return listeners, nil
+}
+
+func serverOptions(config *Config) (server.Options, error) {
+	options := server.DefaultOptions()
+	options.Version = Version
+	options.ACL = acl.New(config.RequirePass)
+	if config.ACLFile != "" {
+		if err := options.ACL.LoadFile(config.ACLFile); err != nil {
+			return options, err
+		}
+		options.ACLFile = config.ACLFile
+		log.Printf("ACL file: %s", config.ACLFile)
+	}
+	if config.ProtoMaxArrayLen > 0 {
+		options.Limits.MaxArrayLength = config.ProtoMaxArrayLen
+	}
+	if config.ProtoMaxBulkLen > 0 {
+		options.Limits.MaxBulkLength = config.ProtoMaxBulkLen
+	}
+	if config.ProtoMaxInlineLen > 0 {
+		options.Limits.MaxInlineLength = config.ProtoMaxInlineLen
+	}
+	if config.ClientQueryBufferLimit > 0 {
+		options.Limits.MaxQueryBuffer = config.ClientQueryBufferLimit
+	}
+	if config.MaxClients > 0 {
+		options.MaxClients = config.MaxClients
+	}
+	if config.Timeout > 0 {
+		options.IdleTimeout = time.Duration(config.Timeout) * time.Second
+	}
+	if config.ClientOutputBufferLimit > 0 {
+		options.OutputBufferLimit = config.ClientOutputBufferLimit
+	}
+	slowlogThreshold := slowlog.DefaultThreshold
+	if config.SlowlogLogSlowerThan != 0 {
+		slowlogThreshold = time.Duration(config.SlowlogLogSlowerThan) * time.Microsecond
+	}
+	slowlogMaxLen := slowlog.DefaultMaxLen
+	if config.SlowlogMaxLen > 0 {
+		slowlogMaxLen = config.SlowlogMaxLen
+	}
+	options.SlowLog = slowlog.New(slowlogThreshold, slowlogMaxLen)
+	return options, nil
 }
 
 func loadConfig() *Config {
 	var (
-		addressFlag = flag.String("address", "", "Server address (default: :6379)")
-		aofPathFlag = flag.String("aof-path", "", "Path to AOF file (if not provided, AOF is disabled)")
-		configFile  = flag.String("config", "", "Path to JSON config file")
+		addressFlag  = flag.String("address", "", "Server address (default: :6379)")
+		aofPathFlag  = flag.String("aof-path", "", "Path to AOF file (if not provided, AOF is disabled)")
+		snapshotFlag = flag.String("snapshot-path", "", "Path to snapshot file (if not provided, snapshots are disabled)")
+		configFile   = flag.String("config", "", "Path to JSON config file")
+		unixFlag     = flag.String("unix-socket", "", "Path to Unix socket to listen on (if not provided, Unix socket is disabled)")
+		metricsFlag  = flag.String("metrics-address", "", "HTTP address for Prometheus metrics (if not provided, metrics are disabled)")
+		recoverTime  = flag.String("recover-to-time", "", "Restore the state as of this time, RFC 3339 or unix milliseconds, cutting the AOF off there")
+		recoverTo    = flag.Int64("recover-to-offset", 0, "Restore the state as of this AOF offset, cutting the AOF off there")
 	)
 	flag.Parse()
 
@@ -78,6 +376,39 @@ func loadConfig() *Config {
 		if fileConfig.AOFPath != "" {
 			config.AOFPath = fileConfig.AOFPath
 		}
+		config.AppendFsync = fileConfig.AppendFsync
+		config.AutoAOFRewritePercentage = fileConfig.AutoAOFRewritePercentage
+		config.AutoAOFRewriteMinSize = fileConfig.AutoAOFRewriteMinSize
+		config.AOFUseSnapshotPreamble = fileConfig.AOFUseSnapshotPreamble
+		config.AOFLoadTruncated = fileConfig.AOFLoadTruncated
+		config.AOFAnnotations = fileConfig.AOFAnnotations
+		config.AOFMultiPart = fileConfig.AOFMultiPart
+		config.AOFMaxPartSize = fileConfig.AOFMaxPartSize
+		config.SnapshotPath = fileConfig.SnapshotPath
+		config.Save = fileConfig.Save
+		config.ProtoMaxArrayLen = fileConfig.ProtoMaxArrayLen
+		config.ProtoMaxBulkLen = fileConfig.ProtoMaxBulkLen
+		config.ProtoMaxInlineLen = fileConfig.ProtoMaxInlineLen
+		config.ClientQueryBufferLimit = fileConfig.ClientQueryBufferLimit
+		config.RequirePass = fileConfig.RequirePass
+		config.ACLFile = fileConfig.ACLFile
+		config.TLSAddress = fileConfig.TLSAddress
+		config.TLSCertFile = fileConfig.TLSCertFile
+		config.TLSKeyFile = fileConfig.TLSKeyFile
+		config.TLSCAFile = fileConfig.TLSCAFile
+		config.TLSClientAuth = fileConfig.TLSClientAuth
+		config.TLSMinVersion = fileConfig.TLSMinVersion
+		config.DisablePlaintext = fileConfig.DisablePlaintext
+		config.UnixSocket = fileConfig.UnixSocket
+		config.UnixSocketPerm = fileConfig.UnixSocketPerm
+		config.MaxClients = fileConfig.MaxClients
+		config.Timeout = fileConfig.Timeout
+		config.TCPKeepAlive = fileConfig.TCPKeepAlive
+		config.ClientOutputBufferLimit = fileConfig.ClientOutputBufferLimit
+		config.SlowlogLogSlowerThan = fileConfig.SlowlogLogSlowerThan
+		config.SlowlogMaxLen = fileConfig.SlowlogMaxLen
+		config.MetricsAddress = fileConfig.MetricsAddress
+		config.ShutdownTimeout = fileConfig.ShutdownTimeout
 	}
 
 	// CLI flags override config file values
@@ -87,6 +418,20 @@ func loadConfig() *Config {
 	if *aofPathFlag != "" {
 		config.AOFPath = *aofPathFlag
 	}
+	if *snapshotFlag != "" {
+		config.SnapshotPath = *snapshotFlag
+	}
+	if *unixFlag != "" {
+		config.UnixSocket = *unixFlag
+	}
+	if *metricsFlag != "" {
+		config.MetricsAddress = *metricsFlag
+	}
+	target, err := replay.ParseTarget(*recoverTime, *recoverTo)
+	if err != nil {
+		log.Fatalf("Failed to configure recovery: %v", err)
+	}
+	config.RecoverTo = target
 
 	return config
 }
@@ -107,37 +452,38 @@ func loadConfigFromFile(path string) (*Config, error) {
 	return &config, nil
 }
 
-func startServer(ctx context.Context, storage *store.Storage, aof *persistence.AOF, address string) {
+func startServer(ctx context.Context, storage *store.Storage, aof *persistence.AOF, listeners []net.Listener, options server.Options, shutdownTimeout time.Duration) {
 	var wg sync.WaitGroup
 
-	listener, err := net.Listen("tcp", address)
-	if err != nil {
-		// Might be better to return an error and let the caller handle it
-		log.Fatalf("Failed to listen: %v", err)
-	}
-
 	go func() {
 		<-ctx.Done()
-		listener.Close()
+		for _, listener := range listeners {
+			listener.Close()
+		}
 	}()
 
-	log.Printf("Listening on %s", listener.Addr())
+	var acceptors sync.WaitGroup
+	for _, listener := range listeners {
+		log.Printf("Listening on %s", listener.Addr())
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			if ctx.Err() != nil {
-				break
+		acceptors.Go(func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("Failed to accept connection: %v", err)
+					continue
+				}
 
+				wg.Go(func() {
+					server.HandleConnection(ctx, conn, storage, aof, options)
+				})
 			}
-			log.Printf("Failed to accept connection: %v", err)
-			continue
-		}
-
-		wg.Go(func() {
-			server.HandleConnection(conn, storage, aof)
 		})
 	}
+	acceptors.Wait()
 
 	done := make(chan struct{})
 	go func() {
@@ -148,31 +494,44 @@ func startServer(ctx context.Context, storage *store.Storage, aof *persistence.A
 	select {
 	case <-done:
 		log.Println("Server shutdown complete")
-	case <-time.After(30 * time.Second):
+	case <-time.After(shutdownTimeout):
 		log.Println("Server shutdown forced")
 	}
 }
 
-func replayAOF(storage *store.Storage, filename string) error {
-	f, err := os.Open(filename)
+// loadAOF replays the AOF into storage. With from set, only what follows
+// that position is replayed, and false is returned without replaying
+// anything if the AOF no longer matches it. An incomplete command at the
+// end, as left by a crash mid-write, is cut off with a warning if
+// loadTruncated is set and is an error otherwise.
+func loadAOF(storage *store.Storage, filename string, from *persistence.AOFPosition, loadTruncated bool) (bool, error) {
+	file, err := persistence.OpenAOF(filename)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+		if errors.Is(err, fs.ErrNotExist) {
+			return from == nil, nil
 		}
-		return err
+		return false, err
 	}
-	defer f.Close()
+	defer file.Close()
 
-	reader := bufio.NewReader(f)
-	for {
-		args, err := protocol.ReadCommand(reader)
-		if err == io.EOF {
-			break
+	reader := replay.NewReader(file)
+	if from != nil {
+		matched, err := reader.Seek(*from)
+		if err != nil || !matched {
+			return false, err
 		}
-		if err != nil {
-			return err
+	} else if _, err := reader.ReadPreamble(storage.Restore); err != nil {
+		return false, err
+	}
+
+	err = reader.Apply(storage)
+	var truncated *replay.TruncatedError
+	if errors.As(err, &truncated) {
+		if !loadTruncated {
+			return true, fmt.Errorf("%w, set aof_load_truncated to cut it off", err)
 		}
-		protocol.DispatchCommand(protocol.DispatchModePrivate, args, storage, nil)
+		log.Printf("Warning: %v, truncating the AOF to %d bytes", err, truncated.Offset)
+		err = persistence.TruncateAOF(filename, truncated.Offset)
 	}
-	return nil
+	return true, err
 }
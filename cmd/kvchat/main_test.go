@@ -1,15 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/flash10042/kv-chat/internal/persistence"
 	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/server"
 	"github.com/flash10042/kv-chat/internal/store"
 )
 
-func TestReplayAOF_EmptyFile(t *testing.T) {
+func TestLoadAOF_EmptyFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -21,9 +33,9 @@ func TestReplayAOF_EmptyFile(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF should not error on empty file: %v", err)
+		t.Fatalf("loadAOF should not error on empty file: %v", err)
 	}
 
 	// Storage should be empty
@@ -32,18 +44,18 @@ func TestReplayAOF_EmptyFile(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_NonExistentFile(t *testing.T) {
+func TestLoadAOF_NonExistentFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "nonexistent.aof")
 
 	storage := store.NewStorage()
-	err := replayAOF(storage, filename)
+	_, err := loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF should not error on non-existent file: %v", err)
+		t.Fatalf("loadAOF should not error on non-existent file: %v", err)
 	}
 }
 
-func TestReplayAOF_SetCommand(t *testing.T) {
+func TestLoadAOF_SetCommand(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -62,9 +74,9 @@ func TestReplayAOF_SetCommand(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify value was set
@@ -77,7 +89,7 @@ func TestReplayAOF_SetCommand(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_MultipleSetCommands(t *testing.T) {
+func TestLoadAOF_MultipleSetCommands(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -103,9 +115,9 @@ func TestReplayAOF_MultipleSetCommands(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify all values were set
@@ -129,7 +141,7 @@ func TestReplayAOF_MultipleSetCommands(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_OverwriteKey(t *testing.T) {
+func TestLoadAOF_OverwriteKey(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -154,9 +166,9 @@ func TestReplayAOF_OverwriteKey(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify final value
@@ -169,7 +181,7 @@ func TestReplayAOF_OverwriteKey(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_ListCommands(t *testing.T) {
+func TestLoadAOF_ListCommands(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -195,9 +207,9 @@ func TestReplayAOF_ListCommands(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify list contents
@@ -217,7 +229,7 @@ func TestReplayAOF_ListCommands(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_DelCommand(t *testing.T) {
+func TestLoadAOF_DelCommand(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -243,9 +255,9 @@ func TestReplayAOF_DelCommand(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify key1 was deleted
@@ -259,7 +271,7 @@ func TestReplayAOF_DelCommand(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_MixedCommands(t *testing.T) {
+func TestLoadAOF_MixedCommands(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -287,9 +299,9 @@ func TestReplayAOF_MixedCommands(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify final state
@@ -314,12 +326,12 @@ func TestReplayAOF_MixedCommands(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_PrivateCommands(t *testing.T) {
+func TestLoadAOF_PrivateCommands(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
 	// Create AOF file with private commands (EXPIREAT, SETEXAT)
-	// These should be replayable since replayAOF uses DispatchModePrivate
+	// These should be replayable since loadAOF uses DispatchModePrivate
 	file, err := os.Create(filename)
 	if err != nil {
 		t.Fatalf("Failed to create file: %v", err)
@@ -340,9 +352,9 @@ func TestReplayAOF_PrivateCommands(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF failed: %v", err)
+		t.Fatalf("loadAOF failed: %v", err)
 	}
 
 	// Verify key exists and has expiration set
@@ -356,7 +368,7 @@ func TestReplayAOF_PrivateCommands(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_InvalidCommand(t *testing.T) {
+func TestLoadAOF_InvalidCommand(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -376,11 +388,11 @@ func TestReplayAOF_InvalidCommand(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	// replayAOF should not error - it will parse the command and dispatch it
-	// DispatchCommand returns an error response string but doesn't cause replayAOF to fail
-	err = replayAOF(storage, filename)
+	// loadAOF should not error - it will parse the command and dispatch it
+	// DispatchCommand returns an error response string but doesn't cause loadAOF to fail
+	_, err = loadAOF(storage, filename, nil, true)
 	if err != nil {
-		t.Fatalf("replayAOF should not error on unknown command (it just dispatches it): %v", err)
+		t.Fatalf("loadAOF should not error on unknown command (it just dispatches it): %v", err)
 	}
 
 	// Verify no data was stored (since command was invalid)
@@ -389,7 +401,7 @@ func TestReplayAOF_InvalidCommand(t *testing.T) {
 	}
 }
 
-func TestReplayAOF_PartialCommand(t *testing.T) {
+func TestLoadAOF_PartialCommand(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
 
@@ -414,10 +426,10 @@ func TestReplayAOF_PartialCommand(t *testing.T) {
 	file.Close()
 
 	storage := store.NewStorage()
-	err = replayAOF(storage, filename)
-	// replayAOF should not error - it will stop at EOF when reading the partial command
+	_, err = loadAOF(storage, filename, nil, true)
+	// loadAOF should not error - it will stop at EOF when reading the partial command
 	if err != nil {
-		t.Fatalf("replayAOF should not error on partial command (stops at EOF): %v", err)
+		t.Fatalf("loadAOF should not error on partial command (stops at EOF): %v", err)
 	}
 
 	// Verify the complete command was replayed
@@ -434,3 +446,145 @@ func TestReplayAOF_PartialCommand(t *testing.T) {
 		t.Fatal("key2 should not exist - partial command should not be executed")
 	}
 }
+
+func TestStartServer_GracefulShutdown(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+
+	listeners, err := openListeners(&Config{Address: "127.0.0.1:0"}, nil)
+	if err != nil {
+		t.Fatalf("openListeners failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		startServer(ctx, store.NewStorage(), aof, listeners, server.DefaultOptions(), 10*time.Second)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("SET key value\r\n"))
+	if reply, _ := reader.ReadString('\n'); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("startServer should return without waiting for idle clients")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Shutdown took %v", elapsed)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected client connection to be closed, got %v", err)
+	}
+
+	if err := aof.Close(); err != nil {
+		t.Fatalf("Failed to close AOF: %v", err)
+	}
+	storage := store.NewStorage()
+	if _, err := loadAOF(storage, filename, nil, true); err != nil {
+		t.Fatalf("loadAOF failed: %v", err)
+	}
+	if value, _ := storage.Get("key"); string(value) != "value" {
+		t.Fatalf("Expected 'value', got %q", value)
+	}
+}
+
+func TestLoadAOF_TruncatesIncompleteTail(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	complete := protocol.EncodeCommand([][]byte{[]byte("SET"), []byte("key1"), []byte("value1")})
+	partial := protocol.EncodeCommand([][]byte{[]byte("SET"), []byte("key2"), []byte("value2")})
+	os.WriteFile(filename, append(complete, partial[:len(partial)-4]...), 0644)
+
+	if _, err := loadAOF(store.NewStorage(), filename, nil, true); err != nil {
+		t.Fatalf("loadAOF failed: %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+	if string(data) != string(complete) {
+		t.Fatalf("Expected the AOF to be cut back to the complete command, got %q", data)
+	}
+
+	// Commands appended afterwards replay cleanly
+	aof := persistence.NewAOF(filename)
+	aof.Append(partial)
+	aof.Close()
+	storage := store.NewStorage()
+	if _, err := loadAOF(storage, filename, nil, true); err != nil {
+		t.Fatalf("loadAOF failed: %v", err)
+	}
+	if !storage.Exists("key1") || !storage.Exists("key2") {
+		t.Fatal("Expected both commands to be replayed")
+	}
+}
+
+func TestLoadAOF_Strict(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	complete := protocol.EncodeCommand([][]byte{[]byte("SET"), []byte("key1"), []byte("value1")})
+	data := append(bytes.Clone(complete), "*3\r\n$3\r\nSET"...)
+	os.WriteFile(filename, data, 0644)
+
+	_, err := loadAOF(store.NewStorage(), filename, nil, false)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("offset %d", len(complete))) {
+		t.Fatalf("Expected an error with the offset of the incomplete command, got %v", err)
+	}
+	if after, _ := os.ReadFile(filename); !bytes.Equal(after, data) {
+		t.Fatal("Expected the AOF to be left alone")
+	}
+}
+
+func TestLoadAOF_CorruptedMiddle(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	complete := protocol.EncodeCommand([][]byte{[]byte("SET"), []byte("key1"), []byte("value1")})
+	data := append(bytes.Clone(complete), "*3\r\n$x\r\n"...)
+	data = append(data, complete...)
+	os.WriteFile(filename, data, 0644)
+
+	_, err := loadAOF(store.NewStorage(), filename, nil, true)
+	var corrupted *replay.CorruptedError
+	if !errors.As(err, &corrupted) || corrupted.Offset != int64(len(complete)) {
+		t.Fatalf("Expected corruption at offset %d, got %v", len(complete), err)
+	}
+	if after, _ := os.ReadFile(filename); !bytes.Equal(after, data) {
+		t.Fatal("Expected a corrupted AOF not to be truncated")
+	}
+}
+
+func TestLoadAOF_Annotations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	plain := protocol.EncodeCommand([][]byte{[]byte("SET"), []byte("key1"), []byte("value1")})
+	os.WriteFile(filename, plain, 0644)
+
+	// Annotated entries can follow plain ones in an existing file
+	aof := persistence.NewAOF(filename)
+	aof.SetAnnotations(true)
+	aof.Append(protocol.EncodeCommand([][]byte{[]byte("SET"), []byte("key2"), []byte("value2")}))
+	aof.Close()
+
+	storage := store.NewStorage()
+	if _, err := loadAOF(storage, filename, nil, true); err != nil {
+		t.Fatalf("loadAOF failed: %v", err)
+	}
+	if !storage.Exists("key1") || !storage.Exists("key2") {
+		t.Fatal("Expected both commands to be replayed")
+	}
+
+	// Bit-rot in an annotated entry is never cut off as a truncated tail
+	data, _ := os.ReadFile(filename)
+	data[len(data)-3] ^= 0x01
+	os.WriteFile(filename, data, 0644)
+	_, err := loadAOF(store.NewStorage(), filename, nil, true)
+	var corrupted *replay.CorruptedError
+	if !errors.As(err, &corrupted) || !errors.Is(err, persistence.ErrEntryChecksum) || corrupted.Offset != int64(len(plain)) {
+		t.Fatalf("Expected a checksum error at offset %d, got %v", len(plain), err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/metrics"
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// serverMetrics registers the metrics read from the server state at scrape
+// time. Command and AOF metrics are recorded into metrics.Default as they
+// happen.
+func serverMetrics(storage *store.Storage, aof *persistence.AOF, options server.Options) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	// Stats holds the storage lock, so it's read once per scrape
+	var statsMu sync.Mutex
+	var stats store.Stats
+	registry.OnCollect(func() {
+		snapshot := storage.Stats()
+		statsMu.Lock()
+		stats = snapshot
+		statsMu.Unlock()
+	})
+	keyspace := func() store.Stats {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		return stats
+	}
+
+	registry.GaugeFunc("kvchat_connected_clients", "Currently connected clients.", func() float64 {
+		return float64(options.Clients.Count())
+	})
+	registry.CounterFunc("kvchat_connections_received_total", "Connections accepted.", func() float64 {
+		return float64(options.Stats.ConnectionsReceived())
+	})
+	registry.CounterFunc("kvchat_rejected_connections_total", "Connections refused because of maxclients.", func() float64 {
+		return float64(options.Stats.RejectedConnections())
+	})
+	registry.GaugeFunc("kvchat_keys", "Keys in the keyspace.", func() float64 {
+		return float64(keyspace().Keys)
+	})
+	registry.GaugeFunc("kvchat_keys_with_expiry", "Keys with a TTL.", func() float64 {
+		return float64(keyspace().Expires)
+	})
+	registry.CounterFunc("kvchat_expired_keys_total", "Keys removed because their TTL passed.", func() float64 {
+		return float64(keyspace().ExpiredKeys)
+	})
+	registry.CounterFunc("kvchat_evicted_keys_total", "Keys evicted to free memory.", func() float64 {
+		return float64(keyspace().EvictedKeys)
+	})
+	registry.CounterFunc("kvchat_keyspace_hits_total", "Key lookups by read commands that found the key.", func() float64 {
+		return float64(keyspace().KeyspaceHits)
+	})
+	registry.CounterFunc("kvchat_keyspace_misses_total", "Key lookups by read commands that missed the key.", func() float64 {
+		return float64(keyspace().KeyspaceMisses)
+	})
+	if aof != nil {
+		registry.GaugeFunc("kvchat_aof_size_bytes", "Size of the AOF file.", func() float64 {
+			size, err := aof.Size()
+			if err != nil {
+				return 0
+			}
+			return float64(size)
+		})
+	}
+	return registry
+}
+
+func metricsHandler(registries ...*metrics.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, registry := range registries {
+			if _, err := registry.WriteTo(w); err != nil {
+				// The scraper went away
+				return
+			}
+		}
+	})
+	return mux
+}
+
+// serveMetrics serves /metrics on listener until ctx is done
+func serveMetrics(ctx context.Context, listener net.Listener, handler http.Handler) {
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving metrics on %s", listener.Addr())
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		log.Printf("Metrics server failed: %v", err)
+	}
+}
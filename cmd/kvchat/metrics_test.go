@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flash10042/kv-chat/internal/metrics"
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	storage := store.NewStorage()
+	aof := persistence.NewAOF(filepath.Join(t.TempDir(), "test.aof"))
+	defer aof.Close()
+	options := server.DefaultOptions()
+
+	protocol.DispatchCommand(protocol.DispatchModePublic, [][]byte{[]byte("SET"), []byte("key"), []byte("value")}, storage, aof)
+	protocol.DispatchCommand(protocol.DispatchModePublic, [][]byte{[]byte("GET"), []byte("key")}, storage, aof)
+	storage.SetEx("session", 100, []byte("value"))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveMetrics(ctx, listener, metricsHandler(metrics.Default, serverMetrics(storage, aof, options)))
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("Unexpected content type %q", contentType)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		"# TYPE kvchat_commands_total counter\n",
+		`kvchat_commands_total{cmd="set"} `,
+		`kvchat_command_duration_seconds_count{cmd="get"} `,
+		"# TYPE kvchat_aof_write_duration_seconds histogram\n",
+		"kvchat_aof_write_errors_total 0\n",
+		"kvchat_connected_clients 0\n",
+		"kvchat_keys 2\n",
+		"kvchat_keys_with_expiry 1\n",
+		"kvchat_keyspace_hits_total 1\n",
+		"kvchat_expired_keys_total 0\n",
+		"kvchat_evicted_keys_total 0\n",
+		"kvchat_aof_size_bytes ",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected %q in metrics:\n%s", expected, body)
+		}
+	}
+}
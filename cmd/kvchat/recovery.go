@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// recoverAOF restores storage to the state as of target and cuts the AOF
+// off there, so the server carries on from that point. The original AOF is
+// kept next to it, as a single file even if it had several parts, and its
+// path returned, empty if nothing was cut off.
+func recoverAOF(storage *store.Storage, filename string, target replay.Target) (string, error) {
+	file, err := persistence.OpenAOF(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader := replay.NewReader(file)
+	if _, err := reader.ReadPreamble(storage.Restore); err != nil {
+		return "", err
+	}
+	recovered, err := reader.ApplyUntil(storage, target)
+	var truncated *replay.TruncatedError
+	if errors.As(err, &truncated) {
+		log.Printf("Warning: %v, recovering up to there", err)
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	last := "unknown"
+	if !recovered.Last.IsZero() {
+		last = recovered.Last.Format(time.RFC3339Nano)
+	}
+	log.Printf("Recovered %d commands up to offset %d, the last written at %s", recovered.Commands, recovered.Offset, last)
+
+	if file.Size() == recovered.Offset {
+		return "", nil
+	}
+	backup := fmt.Sprintf("%s.%d.bak", filename, time.Now().Unix())
+	if err := backupAOF(filename, backup); err != nil {
+		return "", fmt.Errorf("failed to back up the AOF: %w", err)
+	}
+	if err := persistence.TruncateAOF(filename, recovered.Offset); err != nil {
+		return "", err
+	}
+	log.Printf("Discarded %d bytes of the AOF, the original is kept at %s", file.Size()-recovered.Offset, backup)
+	return backup, nil
+}
+
+// backupAOF copies all of the AOF at filename to a new file at backup and
+// syncs it
+func backupAOF(filename, backup string) error {
+	src, err := persistence.OpenAOF(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(backup, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
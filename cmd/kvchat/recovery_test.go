@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func encode(args ...string) []byte {
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	return protocol.EncodeCommand(command)
+}
+
+func TestLoadData_Recovery(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.aof")
+	start := time.UnixMilli(1700000000000)
+	var data bytes.Buffer
+	data.Write(persistence.Annotate(encode("SET", "chat", "hello"), start))
+	kept := data.Len()
+	data.Write(persistence.Annotate(encode("DEL", "chat"), start.Add(10*time.Minute)))
+	os.WriteFile(filename, data.Bytes(), 0644)
+
+	// A newer snapshot is ignored
+	snapshot := filepath.Join(dir, "dump.snap")
+	persistence.SaveSnapshot(snapshot, persistence.SnapshotHeader{CreatedAt: time.Now()}, nil)
+
+	config := &Config{AOFPath: filename, SnapshotPath: snapshot, RecoverTo: replay.Target{Time: start.Add(time.Minute)}}
+	storage, err := loadData(config)
+	if err != nil {
+		t.Fatalf("loadData failed: %v", err)
+	}
+	if !storage.Exists("chat") {
+		t.Fatal("Expected the deleted key to be restored")
+	}
+	if after, _ := os.ReadFile(filename); !bytes.Equal(after, data.Bytes()[:kept]) {
+		t.Fatalf("Expected the AOF to be cut off at %d bytes, got %d", kept, len(after))
+	}
+	backups, _ := filepath.Glob(filename + ".*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected a backup of the AOF, got %v", backups)
+	}
+	if backup, _ := os.ReadFile(backups[0]); !bytes.Equal(backup, data.Bytes()) {
+		t.Fatal("Expected the backup to hold the original AOF")
+	}
+
+	if _, err := loadData(&Config{SnapshotPath: snapshot, RecoverTo: config.RecoverTo}); err == nil {
+		t.Fatal("Expected recovery without an AOF to fail")
+	}
+}
+
+func TestRecoverAOF_NothingToCut(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	data := encode("SET", "key", "value")
+	os.WriteFile(filename, data, 0644)
+
+	backup, err := recoverAOF(store.NewStorage(), filename, replay.Target{Time: time.Now()})
+	if err != nil || backup != "" {
+		t.Fatalf("Expected nothing to be cut off, got %q, %v", backup, err)
+	}
+	if after, _ := os.ReadFile(filename); !bytes.Equal(after, data) {
+		t.Fatal("Expected the AOF to be left alone")
+	}
+}
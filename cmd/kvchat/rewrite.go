@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+const (
+	defaultAutoRewritePercentage = 100
+	defaultAutoRewriteMinSize    = 64 * 1024 * 1024
+	autoRewriteInterval          = time.Second
+	// After a failed rewrite the policy waits this long before trying again
+	autoRewriteRetryDelay = 5 * time.Second
+)
+
+// autoRewritePolicy decides when the AOF has grown enough to be rewritten
+type autoRewritePolicy struct {
+	// Growth over the size after the last rewrite, 0 disables it
+	percentage int
+	minSize    int64
+}
+
+func autoRewriteConfig(config *Config) autoRewritePolicy {
+	policy := autoRewritePolicy{percentage: defaultAutoRewritePercentage, minSize: defaultAutoRewriteMinSize}
+	if config.AutoAOFRewritePercentage != nil {
+		policy.percentage = *config.AutoAOFRewritePercentage
+	}
+	if config.AutoAOFRewriteMinSize > 0 {
+		policy.minSize = config.AutoAOFRewriteMinSize
+	}
+	return policy
+}
+
+func (p autoRewritePolicy) due(size, baseSize int64) bool {
+	if p.percentage <= 0 || size < p.minSize {
+		return false
+	}
+	// A rewrite of an empty store can leave an empty file
+	if baseSize <= 0 {
+		baseSize = 1
+	}
+	return (size-baseSize)*100/baseSize >= int64(p.percentage)
+}
+
+// autoRewriteAOF rewrites the AOF whenever the policy says so, until ctx
+// is done
+func autoRewriteAOF(ctx context.Context, aof *persistence.AOF, storage *store.Storage, policy autoRewritePolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := aof.RewriteStatus()
+			if status.InProgress || (status.LastErr != nil && time.Since(status.LastAt) < autoRewriteRetryDelay) {
+				continue
+			}
+			size, err := aof.Size()
+			if err != nil || !policy.due(size, status.BaseSize) {
+				continue
+			}
+			log.Printf("Starting automatic AOF rewrite: %d bytes, %d after the last rewrite", size, status.BaseSize)
+			if err := server.RewriteAOF(aof, storage); err != nil {
+				if !errors.Is(err, persistence.ErrRewriteInProgress) {
+					log.Printf("Automatic AOF rewrite failed: %v", err)
+				}
+				continue
+			}
+			log.Printf("Automatic AOF rewrite finished successfully")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func TestAutoRewritePolicy(t *testing.T) {
+	policy := autoRewritePolicy{percentage: 100, minSize: 1000}
+	tests := []struct {
+		size, baseSize int64
+		expected       bool
+	}{
+		{size: 999, baseSize: 0, expected: false},
+		{size: 1000, baseSize: 0, expected: true},
+		{size: 1500, baseSize: 1000, expected: false},
+		{size: 2000, baseSize: 1000, expected: true},
+	}
+	for _, tt := range tests {
+		if got := policy.due(tt.size, tt.baseSize); got != tt.expected {
+			t.Errorf("due(%d, %d) = %v, expected %v", tt.size, tt.baseSize, got, tt.expected)
+		}
+	}
+
+	disabled := autoRewritePolicy{percentage: 0, minSize: 0}
+	if disabled.due(1<<30, 1) {
+		t.Errorf("Expected a percentage of 0 to disable rewrites")
+	}
+}
+
+func TestAutoRewriteConfig(t *testing.T) {
+	policy := autoRewriteConfig(&Config{})
+	if policy.percentage != defaultAutoRewritePercentage || policy.minSize != defaultAutoRewriteMinSize {
+		t.Fatalf("Unexpected defaults: %+v", policy)
+	}
+	zero := 0
+	if policy := autoRewriteConfig(&Config{AutoAOFRewritePercentage: &zero}); policy.percentage != 0 {
+		t.Fatalf("Expected an explicit 0 to disable rewrites, got %+v", policy)
+	}
+}
+
+func TestAutoRewriteAOF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	defer aof.Close()
+	storage := store.NewStorage()
+
+	for range 100 {
+		storage.Set("key", []byte("value"))
+		aof.Append([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go autoRewriteAOF(ctx, aof, storage, autoRewritePolicy{percentage: 100, minSize: 100}, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for aof.RewriteStatus().LastAt.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the AOF to be rewritten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, _ := os.ReadFile(filename)
+	if strings.Count(string(data), "SET") != 1 {
+		t.Fatalf("Expected a single SET after the rewrite, got %q", data)
+	}
+}
+
+func TestAutoRewriteAOF_RetryDelay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	defer aof.Close()
+	storage := store.NewStorage()
+	for range 100 {
+		aof.Append([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+	}
+	// The rewrite can't create its temporary file
+	os.Mkdir(filename+".rewrite.tmp", 0755)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go autoRewriteAOF(ctx, aof, storage, autoRewritePolicy{percentage: 100, minSize: 100}, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for aof.RewriteStatus().LastErr == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the rewrite to fail")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	failedAt := aof.RewriteStatus().LastAt
+	time.Sleep(100 * time.Millisecond)
+	if lastAt := aof.RewriteStatus().LastAt; !lastAt.Equal(failedAt) {
+		t.Fatalf("Expected no retry within %v, retried after %v", autoRewriteRetryDelay, lastAt.Sub(failedAt))
+	}
+}
+
+func TestLoadAOF_SnapshotPreamble(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	aof.SetSnapshotPreamble(true)
+	storage := store.NewStorage()
+
+	for _, element := range []string{"first", "second", "third"} {
+		dispatch(storage, aof, "RPUSH", "chat", element)
+	}
+	dispatch(storage, aof, "SETEX", "session", "100", "token")
+	if err := server.RewriteAOF(aof, storage); err != nil {
+		t.Fatalf("RewriteAOF failed: %v", err)
+	}
+	// The tail after the preamble is plain commands
+	dispatch(storage, aof, "RPUSH", "chat", "fourth")
+	dispatch(storage, aof, "SET", "greeting", "hello")
+	aof.Close()
+
+	data, _ := os.ReadFile(filename)
+	if !strings.HasPrefix(string(data), "KVSNAP") || !strings.HasSuffix(string(data), "$5\r\nhello\r\n") {
+		t.Fatalf("Expected a snapshot preamble followed by commands, got %q", data)
+	}
+
+	replayed := store.NewStorage()
+	if _, err := loadAOF(replayed, filename, nil, true); err != nil {
+		t.Fatalf("loadAOF failed: %v", err)
+	}
+	expectList(t, replayed, "chat", "first", "second", "third", "fourth")
+	if value, _ := replayed.Get("greeting"); string(value) != "hello" {
+		t.Fatalf("Expected greeting from the tail, got %q", value)
+	}
+	if ttl := replayed.TTL("session"); ttl <= 0 {
+		t.Fatalf("Expected the TTL to survive the preamble, got %d", ttl)
+	}
+}
+
+func TestLoadAOF_CorruptedPreamble(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	aof.SetSnapshotPreamble(true)
+	storage := store.NewStorage()
+	dispatch(storage, aof, "SET", "greeting", "hello")
+	server.RewriteAOF(aof, storage)
+	aof.Close()
+
+	data, _ := os.ReadFile(filename)
+	data[strings.Index(string(data), "hello")] = 'j'
+	os.WriteFile(filename, data, 0644)
+
+	if _, err := loadAOF(store.NewStorage(), filename, nil, true); err == nil {
+		t.Fatal("Expected a corrupted preamble to fail the replay")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+const (
+	saveRulesInterval = time.Second
+	// After a failed save the rules wait this long before trying again
+	saveRetryDelay = 5 * time.Second
+)
+
+// SaveRule asks for a background save once at least Changes writes are
+// older than Seconds
+type SaveRule struct {
+	Seconds int   `json:"seconds"`
+	Changes int64 `json:"changes"`
+}
+
+// loadData restores the storage from the snapshot and the AOF. When both
+// are enabled the AOF is replayed from where the snapshot was taken, or
+// from the start if it has been rewritten since. A recovery target replays
+// the AOF alone.
+func loadData(config *Config) (*store.Storage, error) {
+	storage := store.NewStorage()
+
+	if !config.RecoverTo.IsZero() {
+		if config.AOFPath == "" {
+			return nil, errors.New("point-in-time recovery needs the AOF")
+		}
+		// The snapshot may be newer than the target, so it's all replayed
+		// from the AOF
+		_, err := recoverAOF(storage, config.AOFPath, config.RecoverTo)
+		return storage, err
+	}
+
+	loaded := false
+	var header persistence.SnapshotHeader
+	if config.SnapshotPath != "" {
+		var err error
+		header, err = persistence.LoadSnapshot(config.SnapshotPath, storage.Restore)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			loaded = true
+			log.Printf("Loaded snapshot %s taken at %s", config.SnapshotPath, header.CreatedAt.Format(time.RFC3339))
+		}
+	}
+	if config.AOFPath == "" {
+		return storage, nil
+	}
+
+	loadTruncated := config.AOFLoadTruncated == nil || *config.AOFLoadTruncated
+	if loaded && header.AOF != nil {
+		matched, err := loadAOF(storage, config.AOFPath, header.AOF, loadTruncated)
+		if err != nil || matched {
+			return storage, err
+		}
+	}
+	if loaded && aofSize(config.AOFPath) == 0 {
+		// Nothing to replay, the AOF is seeded from the snapshot instead
+		return storage, nil
+	}
+	if loaded {
+		log.Printf("The AOF doesn't match the snapshot, replaying all of it")
+		storage = store.NewStorage()
+	}
+	_, err := loadAOF(storage, config.AOFPath, nil, loadTruncated)
+	return storage, err
+}
+
+// aofSize returns the size of the AOF at path, zero if it can't be read
+func aofSize(path string) int64 {
+	file, err := persistence.OpenAOF(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	return file.Size()
+}
+
+// saveOnRules runs a background save whenever one of the rules is met,
+// until ctx is done
+func saveOnRules(ctx context.Context, snapshots *server.Snapshots, storage *store.Storage, aof *persistence.AOF, rules []SaveRule, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status := snapshots.Status()
+			if status.InProgress || (status.LastErr != nil && time.Since(status.LastAttempt) < saveRetryDelay) {
+				continue
+			}
+			changes := storage.Changes() - status.ChangesAtSave
+			if !saveDue(rules, changes, time.Since(status.LastSave)) {
+				continue
+			}
+			log.Printf("%d changes since the last save, saving", changes)
+			if err := snapshots.BackgroundSave(storage, aof); err != nil && !errors.Is(err, server.ErrSaveInProgress) {
+				log.Printf("Background save failed: %v", err)
+			}
+		}
+	}
+}
+
+func saveDue(rules []SaveRule, changes int64, elapsed time.Duration) bool {
+	for _, rule := range rules {
+		if changes > 0 && changes >= rule.Changes && elapsed >= time.Duration(rule.Seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// saveOnShutdown writes a last snapshot, waiting for a background save
+// that's still running
+func saveOnShutdown(snapshots *server.Snapshots, storage *store.Storage, aof *persistence.AOF) error {
+	for {
+		err := snapshots.Save(storage, aof)
+		if !errors.Is(err, server.ErrSaveInProgress) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
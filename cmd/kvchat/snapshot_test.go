@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func dispatch(storage *store.Storage, aof *persistence.AOF, args ...string) {
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	protocol.DispatchCommand(protocol.DispatchModePublic, command, storage, aof)
+}
+
+func expectList(t *testing.T, storage *store.Storage, key string, expected ...string) {
+	t.Helper()
+	list, _ := storage.LRange(key, 0, -1)
+	if len(list) != len(expected) {
+		t.Fatalf("Expected %s to be %q, got %q", key, expected, list)
+	}
+	for i := range list {
+		if string(list[i]) != expected[i] {
+			t.Fatalf("Expected %s to be %q, got %q", key, expected, list)
+		}
+	}
+}
+
+func TestLoadData_SnapshotOnly(t *testing.T) {
+	config := &Config{SnapshotPath: filepath.Join(t.TempDir(), "dump.kvs")}
+	storage := store.NewStorage()
+	storage.RPush("chat", []byte("hello"))
+	if err := server.NewSnapshots(config.SnapshotPath, 0).Save(storage, nil); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := loadData(config)
+	if err != nil {
+		t.Fatalf("loadData failed: %v", err)
+	}
+	expectList(t, loaded, "chat", "hello")
+}
+
+func TestLoadData_SnapshotAndAOF(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{SnapshotPath: filepath.Join(dir, "dump.kvs"), AOFPath: filepath.Join(dir, "test.aof")}
+	aof := persistence.NewAOF(config.AOFPath)
+	storage := store.NewStorage()
+
+	dispatch(storage, aof, "RPUSH", "chat", "first")
+	dispatch(storage, aof, "SET", "greeting", "hello")
+	if err := server.NewSnapshots(config.SnapshotPath, 0).Save(storage, aof); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// Only these must be replayed on top of the snapshot
+	dispatch(storage, aof, "RPUSH", "chat", "second")
+	dispatch(storage, aof, "DEL", "greeting")
+	aof.Close()
+
+	loaded, err := loadData(config)
+	if err != nil {
+		t.Fatalf("loadData failed: %v", err)
+	}
+	expectList(t, loaded, "chat", "first", "second")
+	if loaded.Exists("greeting") {
+		t.Fatal("Expected the DEL after the snapshot to be replayed")
+	}
+}
+
+func TestLoadData_AOFRewrittenSinceSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{SnapshotPath: filepath.Join(dir, "dump.kvs"), AOFPath: filepath.Join(dir, "test.aof")}
+	aof := persistence.NewAOF(config.AOFPath)
+	storage := store.NewStorage()
+
+	dispatch(storage, aof, "SET", "greeting", "hello")
+	server.NewSnapshots(config.SnapshotPath, 0).Save(storage, aof)
+	dispatch(storage, aof, "RPUSH", "chat", "first")
+	dispatch(storage, aof, "RPUSH", "chat", "second")
+	// The rewritten AOF starts with the list, so it no longer matches the
+	// snapshot
+	if err := server.RewriteAOF(aof, storage); err != nil {
+		t.Fatalf("RewriteAOF failed: %v", err)
+	}
+	dispatch(storage, aof, "RPUSH", "chat", "third")
+	aof.Close()
+
+	loaded, err := loadData(config)
+	if err != nil {
+		t.Fatalf("loadData failed: %v", err)
+	}
+	expectList(t, loaded, "chat", "first", "second", "third")
+	if value, _ := loaded.Get("greeting"); string(value) != "hello" {
+		t.Fatalf("Expected greeting from the AOF, got %q", value)
+	}
+}
+
+func TestLoadData_EmptyAOF(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{SnapshotPath: filepath.Join(dir, "dump.kvs"), AOFPath: filepath.Join(dir, "test.aof")}
+	storage := store.NewStorage()
+	storage.Set("greeting", []byte("hello"))
+	server.NewSnapshots(config.SnapshotPath, 0).Save(storage, nil)
+
+	loaded, err := loadData(config)
+	if err != nil {
+		t.Fatalf("loadData failed: %v", err)
+	}
+	if value, _ := loaded.Get("greeting"); string(value) != "hello" {
+		t.Fatalf("Expected the snapshot to be loaded without an AOF, got %q", value)
+	}
+}
+
+func TestSaveDue(t *testing.T) {
+	rules := []SaveRule{{Seconds: 3600, Changes: 1}, {Seconds: 60, Changes: 100}}
+	tests := []struct {
+		changes  int64
+		elapsed  time.Duration
+		expected bool
+	}{
+		{changes: 0, elapsed: 2 * time.Hour, expected: false},
+		{changes: 1, elapsed: time.Hour, expected: true},
+		{changes: 99, elapsed: time.Minute, expected: false},
+		{changes: 100, elapsed: time.Minute, expected: true},
+		{changes: 100, elapsed: time.Second, expected: false},
+	}
+	for _, tt := range tests {
+		if got := saveDue(rules, tt.changes, tt.elapsed); got != tt.expected {
+			t.Errorf("saveDue(%d, %v) = %v, expected %v", tt.changes, tt.elapsed, got, tt.expected)
+		}
+	}
+}
+
+func TestSaveOnRules(t *testing.T) {
+	snapshots := server.NewSnapshots(filepath.Join(t.TempDir(), "dump.kvs"), 0)
+	storage := store.NewStorage()
+	storage.Set("key", []byte("value"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go saveOnRules(ctx, snapshots, storage, nil, []SaveRule{{Seconds: 0, Changes: 1}}, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for snapshots.Status().ChangesAtSave != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a save once the rule was met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadData_MultiPartAOF(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{SnapshotPath: filepath.Join(dir, "dump.kvs"), AOFPath: filepath.Join(dir, "aof")}
+	aof := persistence.NewMultiPartAOF(config.AOFPath, persistence.FsyncEverySec)
+	aof.SetMaxPartSize(32)
+	aof.SetSnapshotPreamble(true)
+	storage := store.NewStorage()
+
+	dispatch(storage, aof, "RPUSH", "chat", "first")
+	dispatch(storage, aof, "SET", "greeting", "hello")
+	if err := server.RewriteAOF(aof, storage); err != nil {
+		t.Fatalf("RewriteAOF failed: %v", err)
+	}
+	dispatch(storage, aof, "RPUSH", "chat", "second")
+	if err := server.NewSnapshots(config.SnapshotPath, 0).Save(storage, aof); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// These land in later parts and are replayed on top of the snapshot
+	dispatch(storage, aof, "RPUSH", "chat", "third")
+	dispatch(storage, aof, "DEL", "greeting")
+	aof.Close()
+
+	if manifest, _ := persistence.ReadManifest(config.AOFPath); manifest.Base == "" || len(manifest.Incrs) < 2 {
+		t.Fatalf("Expected a base and several incremental files, got %+v", manifest)
+	}
+	for _, snapshotPath := range []string{config.SnapshotPath, ""} {
+		config.SnapshotPath = snapshotPath
+		loaded, err := loadData(config)
+		if err != nil {
+			t.Fatalf("loadData failed: %v", err)
+		}
+		expectList(t, loaded, "chat", "first", "second", "third")
+		if loaded.Exists("greeting") {
+			t.Fatal("Expected the DEL in the last part to be replayed")
+		}
+	}
+}
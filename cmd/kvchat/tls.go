@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// tlsReloader serves the most recently loaded TLS settings, so certificates
+// can be rotated without restarting the server
+type tlsReloader struct {
+	config  *Config
+	current atomic.Pointer[tls.Config]
+}
+
+func newTLSReloader(config *Config) (*tlsReloader, error) {
+	reloader := &tlsReloader{config: config}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Reload reads the certificate, key and CA files again. On error the
+// previous settings stay in use.
+func (r *tlsReloader) Reload() error {
+	tlsConfig, err := loadTLSConfig(r.config)
+	if err != nil {
+		return err
+	}
+	r.current.Store(tlsConfig)
+	return nil
+}
+
+// TLSConfig returns the config to serve with, which picks up reloads on
+// every new handshake
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.current.Load().MinVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+func loadTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	minVersion, err := parseTLSVersion(config.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := parseTLSClientAuth(config.TLSClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		ClientAuth:   clientAuth,
+	}
+
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if clientAuth != tls.NoClientCert {
+		return nil, fmt.Errorf("tls_ca_file is required to verify client certificates")
+	}
+
+	return tlsConfig, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls_min_version %q, expected 1.2 or 1.3", version)
+	}
+}
+
+func parseTLSClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls_client_auth %q, expected none, optional or require", mode)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// generateCert creates a certificate signed by parent, or a self-signed CA
+// when parent is nil
+func generateCert(t *testing.T, serial int64, parent *testCert, isClient bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "kv-chat test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	switch {
+	case parent == nil:
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	case isClient:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		signer, signerKey = parent.cert, parent.key
+	default:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load key pair: %v", err)
+	}
+	return cert
+}
+
+func writeServerCert(t *testing.T, config *Config, cert *testCert) {
+	t.Helper()
+	if err := os.WriteFile(config.TLSCertFile, cert.certPEM, 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(config.TLSKeyFile, cert.keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func newTLSTestConfig(t *testing.T, ca *testCert) *Config {
+	t.Helper()
+	tmpDir := t.TempDir()
+	config := &Config{
+		TLSAddress:       "127.0.0.1:0",
+		TLSCertFile:      filepath.Join(tmpDir, "server.crt"),
+		TLSKeyFile:       filepath.Join(tmpDir, "server.key"),
+		TLSCAFile:        filepath.Join(tmpDir, "ca.crt"),
+		DisablePlaintext: true,
+	}
+	if err := os.WriteFile(config.TLSCAFile, ca.certPEM, 0600); err != nil {
+		t.Fatalf("Failed to write CA: %v", err)
+	}
+	writeServerCert(t, config, generateCert(t, 2, ca, false))
+	return config
+}
+
+// runTLSServer starts the server on the configured TLS listener and
+// returns its address
+func runTLSServer(t *testing.T, config *Config) (string, *tlsReloader) {
+	t.Helper()
+	reloader, err := newTLSReloader(config)
+	if err != nil {
+		t.Fatalf("newTLSReloader failed: %v", err)
+	}
+	listeners, err := openListeners(config, reloader)
+	if err != nil {
+		t.Fatalf("openListeners failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		startServer(ctx, store.NewStorage(), nil, listeners, server.DefaultOptions(), time.Second)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return listeners[0].Addr().String(), reloader
+}
+
+func pingTLS(address string, clientConfig *tls.Config) (*tls.ConnectionState, error) {
+	conn, err := tls.Dial("tcp", address, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return nil, err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if reply != "+PONG\r\n" {
+		return nil, &net.OpError{Op: "ping", Err: os.ErrInvalid}
+	}
+	state := conn.ConnectionState()
+	return &state, nil
+}
+
+func TestTLS_Ping(t *testing.T) {
+	ca := generateCert(t, 1, nil, false)
+	config := newTLSTestConfig(t, ca)
+	address, _ := runTLSServer(t, config)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	if _, err := pingTLS(address, &tls.Config{RootCAs: pool}); err != nil {
+		t.Fatalf("PING over TLS failed: %v", err)
+	}
+}
+
+func TestTLS_MinVersion(t *testing.T) {
+	ca := generateCert(t, 1, nil, false)
+	config := newTLSTestConfig(t, ca)
+	config.TLSMinVersion = "1.3"
+	address, _ := runTLSServer(t, config)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	_, err := pingTLS(address, &tls.Config{RootCAs: pool, MaxVersion: tls.VersionTLS12})
+	if err == nil {
+		t.Fatal("TLS 1.2 client should be rejected")
+	}
+}
+
+func TestTLS_RequireClientCert(t *testing.T) {
+	ca := generateCert(t, 1, nil, false)
+	config := newTLSTestConfig(t, ca)
+	config.TLSClientAuth = "require"
+	address, _ := runTLSServer(t, config)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	if _, err := pingTLS(address, &tls.Config{RootCAs: pool}); err == nil {
+		t.Fatal("Client without certificate should be rejected")
+	}
+
+	clientCert := generateCert(t, 3, ca, true)
+	clientConfig := &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert.tlsCertificate(t)},
+	}
+	if _, err := pingTLS(address, clientConfig); err != nil {
+		t.Fatalf("Client with certificate should be accepted: %v", err)
+	}
+}
+
+func TestTLS_Reload(t *testing.T) {
+	ca := generateCert(t, 1, nil, false)
+	config := newTLSTestConfig(t, ca)
+	address, reloader := runTLSServer(t, config)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	state, err := pingTLS(address, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("PING over TLS failed: %v", err)
+	}
+	if serial := state.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Fatalf("Expected serial 2, got %d", serial)
+	}
+
+	writeServerCert(t, config, generateCert(t, 4, ca, false))
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	state, err = pingTLS(address, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("PING over TLS failed after reload: %v", err)
+	}
+	if serial := state.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Fatalf("Expected serial 4 after reload, got %d", serial)
+	}
+}
+
+func TestTLS_ReloadKeepsOldCertOnError(t *testing.T) {
+	ca := generateCert(t, 1, nil, false)
+	config := newTLSTestConfig(t, ca)
+	address, reloader := runTLSServer(t, config)
+
+	os.WriteFile(config.TLSCertFile, []byte("garbage"), 0600)
+	if err := reloader.Reload(); err == nil {
+		t.Fatal("Reload should fail with invalid certificate")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	if _, err := pingTLS(address, &tls.Config{RootCAs: pool}); err != nil {
+		t.Fatalf("Old certificate should stay in use: %v", err)
+	}
+}
+
+func TestLoadTLSConfig_Invalid(t *testing.T) {
+	ca := generateCert(t, 1, nil, false)
+
+	testCases := []func(*Config){
+		func(c *Config) { c.TLSCertFile = "" },
+		func(c *Config) { c.TLSMinVersion = "1.0" },
+		func(c *Config) { c.TLSClientAuth = "sometimes" },
+		func(c *Config) { c.TLSCAFile = ""; c.TLSClientAuth = "require" },
+	}
+	for i, modify := range testCases {
+		config := newTLSTestConfig(t, ca)
+		modify(config)
+		if _, err := loadTLSConfig(config); err == nil {
+			t.Errorf("Case %d: loadTLSConfig should fail", i)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/server"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// shortTempDir returns a temp dir short enough for a Unix socket path,
+// t.TempDir can exceed the ~100 byte limit
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "kvchat")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func runServer(t *testing.T, config *Config, storage *store.Storage) context.CancelFunc {
+	t.Helper()
+	listeners, err := openListeners(config, nil)
+	if err != nil {
+		t.Fatalf("openListeners failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		startServer(ctx, storage, nil, listeners, server.DefaultOptions(), time.Second)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "kvchat.sock")
+	config := &Config{
+		UnixSocket:       path,
+		UnixSocketPerm:   "0770",
+		DisablePlaintext: true,
+	}
+	storage := store.NewStorage()
+	stop := runServer(t, config, storage)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Socket file should exist: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0770 {
+		t.Fatalf("Expected permissions 0770, got %o", perm)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("SET greeting hello\r\n"))
+	if reply, _ := reader.ReadString('\n'); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	conn.Close()
+
+	value, _ := storage.Get("greeting")
+	if string(value) != "hello" {
+		t.Fatalf("Expected 'hello', got %q", value)
+	}
+
+	stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Socket file should be removed on shutdown")
+	}
+}
+
+func TestUnixSocket_AlongsideTCP(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "kvchat.sock")
+	config := &Config{
+		Address:    "127.0.0.1:0",
+		UnixSocket: path,
+	}
+	listeners, err := openListeners(config, nil)
+	if err != nil {
+		t.Fatalf("openListeners failed: %v", err)
+	}
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	if len(listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %d", len(listeners))
+	}
+	if listeners[0].Addr().Network() != "tcp" || listeners[1].Addr().Network() != "unix" {
+		t.Fatalf("Unexpected listeners: %v, %v", listeners[0].Addr(), listeners[1].Addr())
+	}
+}
+
+func TestUnixSocket_StaleFile(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "kvchat.sock")
+
+	// Leave a socket file behind without anyone listening on it
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	listener, err = listenUnix(path, defaultUnixSocketPerm)
+	if err != nil {
+		t.Fatalf("listenUnix should replace stale socket: %v", err)
+	}
+	listener.Close()
+}
+
+func TestUnixSocket_InUse(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "kvchat.sock")
+
+	listener, err := listenUnix(path, defaultUnixSocketPerm)
+	if err != nil {
+		t.Fatalf("listenUnix failed: %v", err)
+	}
+	defer listener.Close()
+
+	if _, err := listenUnix(path, defaultUnixSocketPerm); err == nil {
+		t.Fatal("listenUnix should fail when the socket is in use")
+	}
+}
+
+func TestUnixSocket_NotASocket(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "kvchat.sock")
+	os.WriteFile(path, []byte("data"), 0600)
+
+	if _, err := listenUnix(path, defaultUnixSocketPerm); err == nil {
+		t.Fatal("listenUnix should not remove a regular file")
+	}
+}
+
+func TestParseSocketPerm(t *testing.T) {
+	if perm, err := parseSocketPerm(""); err != nil || perm != defaultUnixSocketPerm {
+		t.Fatalf("Expected default permissions, got %o %v", perm, err)
+	}
+	if perm, err := parseSocketPerm("0660"); err != nil || perm != 0660 {
+		t.Fatalf("Expected 0660, got %o %v", perm, err)
+	}
+	if _, err := parseSocketPerm("999"); err == nil {
+		t.Fatal("Expected error for invalid permissions")
+	}
+}
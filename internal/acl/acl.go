@@ -0,0 +1,371 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const DefaultUser = "default"
+
+var (
+	ErrNoPermCommand = errors.New("no permissions to run the command")
+	ErrNoPermKey     = errors.New("no permissions to access a key")
+	ErrDefaultUser   = errors.New("the 'default' user cannot be removed")
+	ErrUserName      = errors.New("Usernames can't contain spaces or control characters")
+)
+
+// Categories commands can be grouped in, "all" matches every command
+var Categories = []string{
+	"all", "read", "write", "keyspace", "string", "list", "connection", "admin", "dangerous",
+}
+
+type User struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool
+	Passwords []string // SHA-256 hex digests
+	Keys      []string // glob patterns
+	// Ordered command rules such as "+@read" or "-del", later rules win
+	Commands []string
+}
+
+func (u *User) CheckPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, p := range u.Passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) CanRun(command string, categories []string) bool {
+	command = strings.ToLower(command)
+	allowed := false
+	for _, rule := range u.Commands {
+		target := rule[1:]
+		var matches bool
+		if category, ok := strings.CutPrefix(target, "@"); ok {
+			matches = category == "all" || slices.Contains(categories, category)
+		} else {
+			matches = target == command
+		}
+		if matches {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+func (u *User) CanAccessKey(key string) bool {
+	for _, pattern := range u.Keys {
+		if matchGlob(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe returns the user as an ACL rule line, the same format ACL LIST
+// returns and the ACL file stores
+func (u *User) Describe() string {
+	parts := []string{"user", u.Name}
+	if u.Enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.NoPass {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.Passwords {
+		parts = append(parts, "#"+p)
+	}
+	for _, k := range u.Keys {
+		parts = append(parts, "~"+k)
+	}
+	if len(u.Commands) == 0 {
+		parts = append(parts, "-@all")
+	}
+	parts = append(parts, u.Commands...)
+	return strings.Join(parts, " ")
+}
+
+func (u *User) clone() *User {
+	return &User{
+		Name:      u.Name,
+		Enabled:   u.Enabled,
+		NoPass:    u.NoPass,
+		Passwords: slices.Clone(u.Passwords),
+		Keys:      slices.Clone(u.Keys),
+		Commands:  slices.Clone(u.Commands),
+	}
+}
+
+func (u *User) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.Enabled = true
+	case "off":
+		u.Enabled = false
+	case "nopass":
+		u.NoPass = true
+		u.Passwords = nil
+	case "resetpass":
+		u.NoPass = false
+		u.Passwords = nil
+	case "allkeys":
+		u.Keys = []string{"*"}
+	case "resetkeys":
+		u.Keys = nil
+	case "allcommands":
+		u.Commands = []string{"+@all"}
+	case "nocommands":
+		u.Commands = nil
+	case "reset":
+		*u = User{Name: u.Name}
+	default:
+		if rule == "" {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': Syntax error", rule)
+		}
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword(rule[1:]))
+		case '<':
+			u.removePassword(hashPassword(rule[1:]))
+		case '#':
+			if !isPasswordHash(rule[1:]) {
+				return fmt.Errorf("Error in ACL SETUSER modifier '%s': The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters", rule)
+			}
+			u.addPassword(rule[1:])
+		case '!':
+			u.removePassword(rule[1:])
+		case '~':
+			if !isToken(rule[1:]) {
+				return fmt.Errorf("Error in ACL SETUSER modifier '%s': Syntax error", rule)
+			}
+			if !slices.Contains(u.Keys, rule[1:]) {
+				u.Keys = append(u.Keys, rule[1:])
+			}
+		case '+', '-':
+			return u.addCommandRule(lower)
+		default:
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': Syntax error", rule)
+		}
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	u.NoPass = false
+	if !slices.Contains(u.Passwords, hash) {
+		u.Passwords = append(u.Passwords, hash)
+	}
+}
+
+func (u *User) removePassword(hash string) {
+	u.Passwords = slices.DeleteFunc(u.Passwords, func(p string) bool {
+		return p == hash
+	})
+}
+
+func (u *User) addCommandRule(rule string) error {
+	target := rule[1:]
+	if !isToken(target) {
+		return fmt.Errorf("Error in ACL SETUSER modifier '%s': Syntax error", rule)
+	}
+	if category, ok := strings.CutPrefix(target, "@"); ok && !slices.Contains(Categories, category) {
+		return fmt.Errorf("Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)
+	}
+	if target == "@all" {
+		// Everything before +@all / -@all is irrelevant, and no rules
+		// at all already means -@all
+		u.Commands = nil
+		if rule[0] == '-' {
+			return nil
+		}
+	}
+	// Drop the previous identical target so the list stays short
+	u.Commands = slices.DeleteFunc(u.Commands, func(r string) bool {
+		return r[1:] == target
+	})
+	u.Commands = append(u.Commands, rule)
+	return nil
+}
+
+// isToken reports whether s can be saved as a single field of an ACL file
+// line
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+	// Serializes SaveFile so an older snapshot can't overwrite a newer one
+	fileMu sync.Mutex
+}
+
+// New creates an ACL with only the default user. With an empty requirePass
+// the default user needs no password, like a fresh Redis.
+func New(requirePass string) *ACL {
+	defaultUser := &User{
+		Name:     DefaultUser,
+		Enabled:  true,
+		NoPass:   true,
+		Keys:     []string{"*"},
+		Commands: []string{"+@all"},
+	}
+	if requirePass != "" {
+		defaultUser.NoPass = false
+		defaultUser.Passwords = []string{hashPassword(requirePass)}
+	}
+	return &ACL{
+		users: map[string]*User{DefaultUser: defaultUser},
+	}
+}
+
+// SetUser creates the user if needed and applies rules in order. Either all
+// rules apply or, on error, none do.
+func (a *ACL) SetUser(name string, rules []string) error {
+	if !isToken(name) {
+		return ErrUserName
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var user *User
+	if existing, ok := a.users[name]; ok {
+		user = existing.clone()
+	} else {
+		user = &User{Name: name}
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(rule); err != nil {
+			return err
+		}
+	}
+	a.users[name] = user
+	return nil
+}
+
+func (a *ACL) GetUser(name string) (User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user, ok := a.users[name]
+	if !ok {
+		return User{}, false
+	}
+	return *user.clone(), true
+}
+
+func (a *ACL) DelUser(name string) (bool, error) {
+	if name == DefaultUser {
+		return false, ErrDefaultUser
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.users[name]; !ok {
+		return false, nil
+	}
+	delete(a.users, name)
+	return true, nil
+}
+
+// List returns every user's rule line, sorted by user name
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = a.users[name].Describe()
+	}
+	return lines
+}
+
+func (a *ACL) Authenticate(name, password string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user, ok := a.users[name]
+	if !ok || !user.Enabled {
+		return false
+	}
+	return user.CheckPassword(password)
+}
+
+// DefaultUserNoPass reports whether new connections are authenticated as
+// the default user without calling AUTH
+func (a *ACL) DefaultUserNoPass() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user := a.users[DefaultUser]
+	return user.Enabled && user.NoPass
+}
+
+// Check reports whether the user may run the command on the given keys
+func (a *ACL) Check(name string, command string, categories []string, keys [][]byte) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user, ok := a.users[name]
+	if !ok || !user.Enabled || !user.CanRun(command, categories) {
+		return ErrNoPermCommand
+	}
+	for _, key := range keys {
+		if !user.CanAccessKey(string(key)) {
+			return ErrNoPermKey
+		}
+	}
+	return nil
+}
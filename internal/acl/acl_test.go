@@ -0,0 +1,194 @@
+package acl
+
+import (
+	"testing"
+)
+
+func TestNew_NoPassword(t *testing.T) {
+	a := New("")
+
+	if !a.DefaultUserNoPass() {
+		t.Fatal("Default user should not need a password")
+	}
+	if !a.Authenticate(DefaultUser, "anything") {
+		t.Fatal("Default user should accept any password")
+	}
+}
+
+func TestNew_RequirePass(t *testing.T) {
+	a := New("secret")
+
+	if a.DefaultUserNoPass() {
+		t.Fatal("Default user should need a password")
+	}
+	if a.Authenticate(DefaultUser, "wrong") {
+		t.Fatal("Wrong password should be rejected")
+	}
+	if !a.Authenticate(DefaultUser, "secret") {
+		t.Fatal("Correct password should be accepted")
+	}
+}
+
+func TestSetUser(t *testing.T) {
+	a := New("")
+
+	err := a.SetUser("alice", []string{"on", ">pass1", "~chat:*", "+@read", "+rpush"})
+	if err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+
+	if !a.Authenticate("alice", "pass1") {
+		t.Fatal("alice should authenticate with pass1")
+	}
+	if a.Authenticate("alice", "pass2") {
+		t.Fatal("alice should not authenticate with pass2")
+	}
+
+	testCases := []struct {
+		command    string
+		categories []string
+		key        string
+		expected   error
+	}{
+		{"GET", []string{"read", "string"}, "chat:1", nil},
+		{"LRANGE", []string{"read", "list"}, "chat:1", nil},
+		{"RPUSH", []string{"write", "list"}, "chat:1", nil},
+		{"SET", []string{"write", "string"}, "chat:1", ErrNoPermCommand},
+		{"GET", []string{"read", "string"}, "user:1", ErrNoPermKey},
+	}
+	for _, tc := range testCases {
+		err := a.Check("alice", tc.command, tc.categories, [][]byte{[]byte(tc.key)})
+		if err != tc.expected {
+			t.Errorf("Check(%s %s): expected %v, got %v", tc.command, tc.key, tc.expected, err)
+		}
+	}
+}
+
+func TestSetUser_Disabled(t *testing.T) {
+	a := New("")
+	a.SetUser("bob", []string{"off", ">pass", "allcommands", "allkeys"})
+
+	if a.Authenticate("bob", "pass") {
+		t.Fatal("Disabled user should not authenticate")
+	}
+	if err := a.Check("bob", "GET", []string{"read"}, nil); err != ErrNoPermCommand {
+		t.Fatalf("Disabled user should not run commands, got %v", err)
+	}
+}
+
+func TestSetUser_LaterRulesWin(t *testing.T) {
+	a := New("")
+	a.SetUser("carol", []string{"on", "nopass", "allkeys", "+@all", "-@write", "+set"})
+
+	if err := a.Check("carol", "SET", []string{"write", "string"}, nil); err != nil {
+		t.Fatalf("SET should be allowed, got %v", err)
+	}
+	if err := a.Check("carol", "DEL", []string{"write", "keyspace"}, nil); err != ErrNoPermCommand {
+		t.Fatalf("DEL should be denied, got %v", err)
+	}
+	if err := a.Check("carol", "GET", []string{"read", "string"}, nil); err != nil {
+		t.Fatalf("GET should be allowed, got %v", err)
+	}
+}
+
+func TestSetUser_InvalidRuleIsAtomic(t *testing.T) {
+	a := New("")
+	a.SetUser("dave", []string{"on", ">pass"})
+
+	err := a.SetUser("dave", []string{"off", "+@nosuchcategory"})
+	if err == nil {
+		t.Fatal("SetUser should fail with unknown category")
+	}
+
+	user, _ := a.GetUser("dave")
+	if !user.Enabled {
+		t.Fatal("Failed SetUser should not apply earlier rules")
+	}
+}
+
+// Names and rules end up as fields of an ACL file line, so they can't
+// hold anything that would split it or start a new one
+func TestSetUser_RejectsWhitespace(t *testing.T) {
+	a := New("")
+	for _, name := range []string{"", "a b", "bob\nuser root on nopass ~* +@all", "tab\there", "nul\x00"} {
+		if err := a.SetUser(name, []string{"on"}); err != ErrUserName {
+			t.Fatalf("Expected ErrUserName for %q, got %v", name, err)
+		}
+	}
+	for _, rule := range []string{"~a b", "~x\nuser root on nopass ~* +@all", "~", "+get\nuser", "-a\x01"} {
+		if err := a.SetUser("bob", []string{rule}); err == nil {
+			t.Fatalf("Expected an error for rule %q", rule)
+		}
+	}
+	if len(a.List()) != 1 {
+		t.Fatalf("Expected only the default user, got %q", a.List())
+	}
+	// Passwords are only saved hashed
+	if err := a.SetUser("bob", []string{"on", ">pass word"}); err != nil {
+		t.Fatalf("Expected a password with a space to be accepted, got %v", err)
+	}
+}
+
+func TestSetUser_PasswordRules(t *testing.T) {
+	a := New("")
+	a.SetUser("erin", []string{"on", ">one", ">two"})
+	a.SetUser("erin", []string{"<one"})
+
+	if a.Authenticate("erin", "one") {
+		t.Fatal("Removed password should be rejected")
+	}
+	if !a.Authenticate("erin", "two") {
+		t.Fatal("Remaining password should be accepted")
+	}
+
+	a.SetUser("erin", []string{"resetpass"})
+	if a.Authenticate("erin", "two") {
+		t.Fatal("resetpass should remove all passwords")
+	}
+
+	if err := a.SetUser("erin", []string{"#" + hashPassword("three")}); err != nil {
+		t.Fatalf("SetUser with hash failed: %v", err)
+	}
+	if !a.Authenticate("erin", "three") {
+		t.Fatal("Password set by hash should be accepted")
+	}
+	if err := a.SetUser("erin", []string{"#nothex"}); err == nil {
+		t.Fatal("Invalid hash should be rejected")
+	}
+}
+
+func TestDelUser(t *testing.T) {
+	a := New("")
+	a.SetUser("frank", []string{"on"})
+
+	deleted, err := a.DelUser("frank")
+	if err != nil || !deleted {
+		t.Fatalf("DelUser should delete frank, got %v %v", deleted, err)
+	}
+	deleted, _ = a.DelUser("frank")
+	if deleted {
+		t.Fatal("DelUser should not delete a missing user")
+	}
+	if _, err := a.DelUser(DefaultUser); err != ErrDefaultUser {
+		t.Fatalf("Expected ErrDefaultUser, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	a := New("")
+	a.SetUser("alice", []string{"on", "nopass", "~chat:*", "+@read"})
+
+	lines := a.List()
+	expected := []string{
+		"user alice on nopass ~chat:* +@read",
+		"user default on nopass ~* +@all",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], lines[i])
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadFile replaces all users with the ones in an ACL file. Each non-empty
+// line is "user <name> <rules...>", lines starting with '#' are comments.
+// A missing file is not an error; it's created on the first save.
+func (a *ACL) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: line should start with user keyword", path, lineNumber)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s'", path, lineNumber, name)
+		}
+		user := &User{Name: name}
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+			}
+		}
+		users[name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// The default user always exists, keep the current one if the file
+	// doesn't mention it
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = a.users[DefaultUser]
+	}
+	a.users = users
+	return nil
+}
+
+// SaveFile writes all users to path, replacing it atomically
+func (a *ACL) SaveFile(path string) error {
+	a.fileMu.Lock()
+	defer a.fileMu.Unlock()
+
+	lines := a.List()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		writer.WriteString(line)
+		writer.WriteString("\n")
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
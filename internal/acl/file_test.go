@@ -0,0 +1,92 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+
+	a := New("secret")
+	a.SetUser("alice", []string{"on", ">pass", "~chat:*", "+@read", "-lrange"})
+	if err := a.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+
+	loaded := New("")
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if !loaded.Authenticate(DefaultUser, "secret") {
+		t.Fatal("Default user password should be loaded")
+	}
+	if !loaded.Authenticate("alice", "pass") {
+		t.Fatal("alice should be loaded")
+	}
+	if err := loaded.Check("alice", "LRANGE", []string{"read", "list"}, nil); err != ErrNoPermCommand {
+		t.Fatalf("LRANGE should be denied, got %v", err)
+	}
+}
+
+func TestSaveFile_RejectedUsersNeverReachTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	a := New("")
+	a.SetUser("bob\nuser root on nopass ~* +@all", []string{"on", ">pw"})
+	a.SetUser("a b", []string{"on"})
+	a.SetUser("alice", []string{"on", "~chat\n*"})
+	if err := a.SaveFile(path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+
+	loaded := New("")
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatalf("Expected the saved file to load, got %v", err)
+	}
+	if users := loaded.List(); len(users) != 1 {
+		t.Fatalf("Expected only the default user, got %q", users)
+	}
+}
+
+func TestLoadFile_Missing(t *testing.T) {
+	a := New("")
+	if err := a.LoadFile(filepath.Join(t.TempDir(), "missing.acl")); err != nil {
+		t.Fatalf("LoadFile should not fail for missing file: %v", err)
+	}
+}
+
+func TestLoadFile_KeepsDefaultUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	os.WriteFile(path, []byte("# comment\n\nuser alice on nopass allkeys allcommands\n"), 0600)
+
+	a := New("secret")
+	if err := a.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if !a.Authenticate(DefaultUser, "secret") {
+		t.Fatal("Default user should be kept")
+	}
+	if !a.Authenticate("alice", "") {
+		t.Fatal("alice should be loaded")
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	testCases := []string{
+		"alice on nopass\n",
+		"user alice on +@nosuchcategory\n",
+		"user alice on\nuser alice off\n",
+	}
+
+	for _, content := range testCases {
+		path := filepath.Join(t.TempDir(), "users.acl")
+		os.WriteFile(path, []byte(content), 0600)
+
+		a := New("")
+		if err := a.LoadFile(path); err == nil {
+			t.Errorf("LoadFile should fail for %q", content)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package acl
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"chat:*", "chat:123", true},
+		{"chat:*", "user:123", false},
+		{"chat:*:history", "chat:1/2:history", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[llo", "h[llo", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+	}
+
+	for _, tc := range testCases {
+		if got := matchGlob(tc.pattern, tc.str); got != tc.match {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tc.pattern, tc.str, got, tc.match)
+		}
+	}
+}
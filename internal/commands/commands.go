@@ -18,44 +18,77 @@ type Command struct {
 	Handler      Handler
 	IsPrivate    bool
 	AOFTransform func(args [][]byte) [][]byte
+	// ACL categories, see acl.Categories
+	Categories []string
+	// Positions of the first and last key argument, 0 if there are no keys
+	FirstKey int
+	LastKey  int
+}
+
+func (c Command) Keys(args [][]byte) [][]byte {
+	if c.FirstKey == 0 {
+		return nil
+	}
+	last := min(c.LastKey, len(args)-1)
+	var keys [][]byte
+	for i := c.FirstKey; i <= last; i++ {
+		keys = append(keys, args[i])
+	}
+	return keys
 }
 
 func init() {
 	register(Command{
-		Name:    "PING",
-		Arity:   1,
-		Mutates: false,
-		Handler: PingHandler,
+		Name:       "PING",
+		Arity:      1,
+		Mutates:    false,
+		Handler:    PingHandler,
+		Categories: []string{"connection"},
 	})
 	register(Command{
-		Name:    "SET",
-		Arity:   3,
-		Mutates: true,
-		Handler: SetHandler,
+		Name:       "SET",
+		Arity:      3,
+		Mutates:    true,
+		Handler:    SetHandler,
+		Categories: []string{"write", "string"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:    "GET",
-		Arity:   2,
-		Mutates: false,
-		Handler: GetHandler,
+		Name:       "GET",
+		Arity:      2,
+		Mutates:    false,
+		Handler:    GetHandler,
+		Categories: []string{"read", "string"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:    "LPUSH",
-		Arity:   3,
-		Mutates: true,
-		Handler: LPushHandler,
+		Name:       "LPUSH",
+		Arity:      3,
+		Mutates:    true,
+		Handler:    LPushHandler,
+		Categories: []string{"write", "list"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:    "RPUSH",
-		Arity:   3,
-		Mutates: true,
-		Handler: RPushHandler,
+		Name:       "RPUSH",
+		Arity:      3,
+		Mutates:    true,
+		Handler:    RPushHandler,
+		Categories: []string{"write", "list"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:    "LRANGE",
-		Arity:   4,
-		Mutates: false,
-		Handler: LRangeHandler,
+		Name:       "LRANGE",
+		Arity:      4,
+		Mutates:    false,
+		Handler:    LRangeHandler,
+		Categories: []string{"read", "list"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
 		Name:         "EXPIRE",
@@ -63,24 +96,36 @@ func init() {
 		Mutates:      true,
 		Handler:      ExpireHandler,
 		AOFTransform: ExpireTransform,
+		Categories:   []string{"write", "keyspace"},
+		FirstKey:     1,
+		LastKey:      1,
 	})
 	register(Command{
-		Name:    "TTL",
-		Arity:   2,
-		Mutates: false,
-		Handler: TTLHandler,
+		Name:       "TTL",
+		Arity:      2,
+		Mutates:    false,
+		Handler:    TTLHandler,
+		Categories: []string{"read", "keyspace"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:    "DEL",
-		Arity:   2,
-		Mutates: true,
-		Handler: DelHandler,
+		Name:       "DEL",
+		Arity:      2,
+		Mutates:    true,
+		Handler:    DelHandler,
+		Categories: []string{"write", "keyspace"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:    "EXISTS",
-		Arity:   2,
-		Mutates: false,
-		Handler: ExistsHandler,
+		Name:       "EXISTS",
+		Arity:      2,
+		Mutates:    false,
+		Handler:    ExistsHandler,
+		Categories: []string{"read", "keyspace"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
 		Name:         "SETEX",
@@ -88,20 +133,29 @@ func init() {
 		Mutates:      true,
 		Handler:      SetExHandler,
 		AOFTransform: SetExTransform,
+		Categories:   []string{"write", "string"},
+		FirstKey:     1,
+		LastKey:      1,
 	})
 	register(Command{
-		Name:      "EXPIREAT",
-		Arity:     3,
-		Mutates:   true,
-		Handler:   ExpireAtHandler,
-		IsPrivate: true,
+		Name:       "EXPIREAT",
+		Arity:      3,
+		Mutates:    true,
+		Handler:    ExpireAtHandler,
+		IsPrivate:  true,
+		Categories: []string{"write", "keyspace"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 	register(Command{
-		Name:      "SETEXAT",
-		Arity:     4,
-		Mutates:   true,
-		Handler:   SetExAtHandler,
-		IsPrivate: true,
+		Name:       "SETEXAT",
+		Arity:      4,
+		Mutates:    true,
+		Handler:    SetExAtHandler,
+		IsPrivate:  true,
+		Categories: []string{"write", "string"},
+		FirstKey:   1,
+		LastKey:    1,
 	})
 }
 
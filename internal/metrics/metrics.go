@@ -0,0 +1,126 @@
+// Package metrics implements the few Prometheus metric types the server
+// needs and writes them in the Prometheus text exposition format
+package metrics
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are latency buckets in seconds, from 10µs to 1s
+var DefaultBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+type Histogram struct {
+	buckets []float64 // upper bounds, sorted
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64 // float64 sum in seconds
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+	h.count.Add(1)
+	for {
+		old := h.sumBits.Load()
+		sum := math.Float64frombits(old) + value
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) ObserveDuration(duration time.Duration) {
+	h.Observe(duration.Seconds())
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(h.sumBits.Load())
+}
+
+// CounterVec is a counter per value of a single label
+type CounterVec struct {
+	label    string
+	mu       sync.RWMutex
+	counters map[string]*Counter
+}
+
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.RLock()
+	counter, ok := v.counters[value]
+	v.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if counter, ok := v.counters[value]; ok {
+		return counter
+	}
+	counter = &Counter{}
+	v.counters[value] = counter
+	return counter
+}
+
+// HistogramVec is a histogram per value of a single label
+type HistogramVec struct {
+	label      string
+	buckets    []float64
+	mu         sync.RWMutex
+	histograms map[string]*Histogram
+}
+
+func (v *HistogramVec) With(value string) *Histogram {
+	v.mu.RLock()
+	histogram, ok := v.histograms[value]
+	v.mu.RUnlock()
+	if ok {
+		return histogram
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if histogram, ok := v.histograms[value]; ok {
+		return histogram
+	}
+	histogram = newHistogram(v.buckets)
+	v.histograms[value] = histogram
+	return histogram
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.Counter("test_total", "A test counter.")
+	counter.Inc()
+	counter.Add(2)
+
+	expected := "# HELP test_total A test counter.\n# TYPE test_total counter\ntest_total 3\n"
+	if got := writeString(t, registry); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	registry := NewRegistry()
+	vec := registry.CounterVec("calls_total", "Calls.", "cmd")
+	vec.With("set").Inc()
+	vec.With("get").Add(2)
+	vec.With(`a"b\c` + "\n").Inc()
+
+	expected := "# HELP calls_total Calls.\n# TYPE calls_total counter\n" +
+		`calls_total{cmd="a\"b\\c\n"} 1` + "\n" +
+		`calls_total{cmd="get"} 2` + "\n" +
+		`calls_total{cmd="set"} 1` + "\n"
+	if got := writeString(t, registry); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.1)
+	histogram.ObserveDuration(500 * time.Millisecond)
+	histogram.Observe(2)
+
+	expected := "# HELP latency_seconds Latency.\n# TYPE latency_seconds histogram\n" +
+		`latency_seconds_bucket{le="0.1"} 2` + "\n" +
+		`latency_seconds_bucket{le="1"} 3` + "\n" +
+		`latency_seconds_bucket{le="+Inf"} 4` + "\n" +
+		"latency_seconds_sum 2.65\n" +
+		"latency_seconds_count 4\n"
+	if got := writeString(t, registry); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	registry := NewRegistry()
+	vec := registry.HistogramVec("duration_seconds", "Duration.", "cmd", []float64{1})
+	vec.With("get").Observe(0.5)
+
+	got := writeString(t, registry)
+	for _, expected := range []string{
+		`duration_seconds_bucket{cmd="get",le="1"} 1`,
+		`duration_seconds_bucket{cmd="get",le="+Inf"} 1`,
+		`duration_seconds_sum{cmd="get"} 0.5`,
+		`duration_seconds_count{cmd="get"} 1`,
+	} {
+		if !strings.Contains(got, expected+"\n") {
+			t.Errorf("Expected %q in %q", expected, got)
+		}
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	registry := NewRegistry()
+	registry.GaugeFunc("clients", "Clients.", func() float64 { return 7 })
+	registry.CounterFunc("expired_total", "Expired.", func() float64 { return 1.5 })
+
+	expected := "# HELP clients Clients.\n# TYPE clients gauge\nclients 7\n" +
+		"# HELP expired_total Expired.\n# TYPE expired_total counter\nexpired_total 1.5\n"
+	if got := writeString(t, registry); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestOnCollect(t *testing.T) {
+	registry := NewRegistry()
+	collected, snapshot := 0, 0
+	registry.OnCollect(func() {
+		collected++
+		snapshot = collected * 10
+	})
+	registry.GaugeFunc("first", "First.", func() float64 { return float64(snapshot) })
+	registry.GaugeFunc("second", "Second.", func() float64 { return float64(snapshot + 1) })
+
+	writeString(t, registry)
+	got := writeString(t, registry)
+	if collected != 2 || !strings.Contains(got, "first 20\n") || !strings.Contains(got, "second 21\n") {
+		t.Fatalf("Expected one collection per scrape, got %d:\n%s", collected, got)
+	}
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("test_total", "A test counter.")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic on duplicate registration")
+		}
+	}()
+	registry.Counter("test_total", "A test counter.")
+}
+
+func TestHistogram_Concurrent(t *testing.T) {
+	registry := NewRegistry()
+	vec := registry.HistogramVec("duration_seconds", "Duration.", "cmd", DefaultBuckets)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 1000 {
+				vec.With("get").Observe(0.001)
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 10 {
+			writeString(t, registry)
+		}
+	})
+	wg.Wait()
+
+	histogram := vec.With("get")
+	if histogram.Count() != 8000 {
+		t.Fatalf("Expected 8000 observations, got %d", histogram.Count())
+	}
+	if sum := histogram.Sum(); sum < 7.99 || sum > 8.01 {
+		t.Fatalf("Expected sum of 8, got %v", sum)
+	}
+}
+
+func writeString(t *testing.T, registry *Registry) string {
+	t.Helper()
+	var builder strings.Builder
+	n, err := registry.WriteTo(&builder)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if int(n) != builder.Len() {
+		t.Fatalf("WriteTo reported %d bytes, wrote %d", n, builder.Len())
+	}
+	return builder.String()
+}
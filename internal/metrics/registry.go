@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	write func(w *bufio.Writer, name string)
+}
+
+// Registry holds metrics in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+	order   []string
+	// Run at the start of every scrape
+	collectHooks []func()
+}
+
+// Default is where the dispatcher and the AOF register their metrics
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[m.name]; ok {
+		panic(fmt.Sprintf("Metric %s already registered", m.name))
+	}
+	r.metrics[m.name] = m
+	r.order = append(r.order, m.name)
+}
+
+func (r *Registry) Counter(name, help string) *Counter {
+	counter := &Counter{}
+	r.register(metric{name, help, typeCounter, func(w *bufio.Writer, name string) {
+		writeSample(w, name, "", "", formatUint(counter.Value()))
+	}})
+	return counter
+}
+
+func (r *Registry) CounterVec(name, help, label string) *CounterVec {
+	vec := &CounterVec{label: label, counters: make(map[string]*Counter)}
+	r.register(metric{name, help, typeCounter, func(w *bufio.Writer, name string) {
+		vec.mu.RLock()
+		defer vec.mu.RUnlock()
+		for _, value := range sortedKeys(vec.counters) {
+			writeSample(w, name, vec.label, value, formatUint(vec.counters[value].Value()))
+		}
+	}})
+	return vec
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	histogram := newHistogram(buckets)
+	r.register(metric{name, help, typeHistogram, func(w *bufio.Writer, name string) {
+		writeHistogram(w, name, "", "", histogram)
+	}})
+	return histogram
+}
+
+func (r *Registry) HistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	vec := &HistogramVec{label: label, buckets: buckets, histograms: make(map[string]*Histogram)}
+	r.register(metric{name, help, typeHistogram, func(w *bufio.Writer, name string) {
+		vec.mu.RLock()
+		defer vec.mu.RUnlock()
+		for _, value := range sortedKeys(vec.histograms) {
+			writeHistogram(w, name, vec.label, value, vec.histograms[value])
+		}
+	}})
+	return vec
+}
+
+// GaugeFunc reports the value returned by fn at scrape time
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(metric{name, help, typeGauge, func(w *bufio.Writer, name string) {
+		writeSample(w, name, "", "", formatFloat(fn()))
+	}})
+}
+
+// CounterFunc reports a counter kept elsewhere, read at scrape time
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(metric{name, help, typeCounter, func(w *bufio.Writer, name string) {
+		writeSample(w, name, "", "", formatFloat(fn()))
+	}})
+}
+
+// OnCollect runs fn at the start of every scrape, before any metric is
+// read, so several metrics can share one expensive snapshot
+func (r *Registry) OnCollect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectHooks = append(r.collectHooks, fn)
+}
+
+// WriteTo writes every metric in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, len(r.order))
+	for i, name := range r.order {
+		metrics[i] = r.metrics[name]
+	}
+	hooks := r.collectHooks
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	counter := &countingWriter{w: w}
+	writer := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		fmt.Fprintf(writer, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(writer, "# TYPE %s %s\n", m.name, m.kind)
+		m.write(writer, m.name)
+	}
+	err := writer.Flush()
+	return counter.n, err
+}
+
+func writeHistogram(w *bufio.Writer, name, label, value string, histogram *Histogram) {
+	labels := ""
+	if label != "" {
+		labels = label + `="` + escapeLabel(value) + `",`
+	}
+	var cumulative uint64
+	for i, bound := range histogram.buckets {
+		cumulative += histogram.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	// An observation in flight may already be in a bucket but not in the count
+	count := max(histogram.Count(), cumulative)
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, count)
+	writeSample(w, name+"_sum", label, value, formatFloat(histogram.Sum()))
+	writeSample(w, name+"_count", label, value, formatUint(count))
+}
+
+func writeSample(w *bufio.Writer, name, label, value, sample string) {
+	w.WriteString(name)
+	if label != "" {
+		w.WriteString("{" + label + `="` + escapeLabel(value) + `"}`)
+	}
+	w.WriteString(" " + sample + "\n")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatUint(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
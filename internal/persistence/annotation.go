@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With annotations enabled each entry is preceded by a line like
+//
+//	#TS:1700000000123 CRC:1a2b3c4d\r\n
+//
+// holding when it was written, in unix milliseconds, and the CRC-32C of the
+// entry. Entries without one, as in files written before annotations
+// existed, stay valid. Unknown fields are ignored.
+const AnnotationPrefix = '#'
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrEntryChecksum = errors.New("entry checksum mismatch")
+
+type Annotation struct {
+	Time     time.Time // zero if not recorded
+	Checksum uint32
+	// Whether Checksum was recorded
+	HasChecksum bool
+}
+
+// EntryChecksum returns the checksum recorded for an encoded command
+func EntryChecksum(command []byte) uint32 {
+	return crc32.Checksum(command, castagnoli)
+}
+
+// Annotate prefixes an encoded command with its annotation
+func Annotate(command []byte, at time.Time) []byte {
+	line := fmt.Sprintf("%cTS:%d CRC:%08x\r\n", AnnotationPrefix, at.UnixMilli(), EntryChecksum(command))
+	return append([]byte(line), command...)
+}
+
+// ParseAnnotation parses an annotation line, with or without its line
+// ending
+func ParseAnnotation(line string) (Annotation, error) {
+	var annotation Annotation
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 || line[0] != AnnotationPrefix {
+		return annotation, errors.New("not an annotation")
+	}
+	for _, field := range strings.Fields(line[1:]) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return annotation, fmt.Errorf("invalid annotation field %q", field)
+		}
+		switch key {
+		case "TS":
+			ms, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return annotation, fmt.Errorf("invalid annotation timestamp %q", value)
+			}
+			annotation.Time = time.UnixMilli(ms)
+		case "CRC":
+			checksum, err := strconv.ParseUint(value, 16, 32)
+			if err != nil {
+				return annotation, fmt.Errorf("invalid annotation checksum %q", value)
+			}
+			annotation.Checksum = uint32(checksum)
+			annotation.HasChecksum = true
+		}
+	}
+	return annotation, nil
+}
@@ -0,0 +1,75 @@
+package persistence
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnnotation_RoundTrip(t *testing.T) {
+	command := []byte("*1\r\n$4\r\nPING\r\n")
+	at := time.UnixMilli(1700000000123)
+
+	annotated := Annotate(command, at)
+	line, rest, ok := bytes.Cut(annotated, []byte("\n"))
+	if !ok || !bytes.Equal(rest, command) {
+		t.Fatalf("Expected the annotation followed by the command, got %q", annotated)
+	}
+	annotation, err := ParseAnnotation(string(line))
+	if err != nil {
+		t.Fatalf("ParseAnnotation failed: %v", err)
+	}
+	if !annotation.Time.Equal(at) || !annotation.HasChecksum || annotation.Checksum != EntryChecksum(command) {
+		t.Fatalf("Unexpected annotation %+v", annotation)
+	}
+}
+
+func TestParseAnnotation(t *testing.T) {
+	annotation, err := ParseAnnotation("#TS:5 NEW:field\r\n")
+	if err != nil {
+		t.Fatalf("Expected unknown fields to be ignored, got %v", err)
+	}
+	if annotation.Time.UnixMilli() != 5 || annotation.HasChecksum {
+		t.Fatalf("Unexpected annotation %+v", annotation)
+	}
+
+	for _, line := range []string{"", "TS:5", "#TS:abc", "#CRC:xyz", "#TS"} {
+		if _, err := ParseAnnotation(line); err == nil {
+			t.Fatalf("Expected an error for %q", line)
+		}
+	}
+}
+
+func TestAOF_Annotations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := NewAOF(filename)
+	command := []byte("*1\r\n$4\r\nPING\r\n")
+
+	if err := aof.Append(command); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	aof.SetAnnotations(true)
+	before := time.Now().Truncate(time.Millisecond)
+	if err := aof.Append(command); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	aof.Close()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read AOF: %v", err)
+	}
+	if !bytes.HasPrefix(data, command) {
+		t.Fatalf("Expected the first command to be plain, got %q", data)
+	}
+	line, rest, _ := bytes.Cut(data[len(command):], []byte("\n"))
+	annotation, err := ParseAnnotation(string(line))
+	if err != nil {
+		t.Fatalf("Expected an annotation, got %q: %v", data, err)
+	}
+	if annotation.Time.Before(before) || annotation.Checksum != EntryChecksum(command) || !bytes.Equal(rest, command) {
+		t.Fatalf("Unexpected annotated entry %q", data[len(command):])
+	}
+}
@@ -1,38 +1,504 @@
 package persistence
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/metrics"
+)
+
+var (
+	writeDuration = metrics.Default.Histogram("kvchat_aof_write_duration_seconds",
+		"Time spent writing batches of commands to the AOF.", metrics.DefaultBuckets)
+	writeErrors = metrics.Default.Counter("kvchat_aof_write_errors_total",
+		"Failed AOF writes.")
+	fsyncDuration = metrics.Default.Histogram("kvchat_aof_fsync_duration_seconds",
+		"Time spent in fsync of the AOF.", metrics.DefaultBuckets)
+	fsyncErrors = metrics.Default.Counter("kvchat_aof_fsync_errors_total",
+		"Failed AOF fsyncs.")
+	batchSize = metrics.Default.Histogram("kvchat_aof_batch_commands",
+		"Commands written to the AOF per batch.", []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024})
+)
+
+// FsyncPolicy says when appended commands are flushed to disk
+type FsyncPolicy int
+
+const (
+	// FsyncEverySec fsyncs in the background once a second, so a crash
+	// loses at most about a second of writes
+	FsyncEverySec FsyncPolicy = iota
+	// FsyncAlways fsyncs every batch before it's acknowledged, so
+	// acknowledged writes are never lost
+	FsyncAlways
+	// FsyncNo leaves flushing to the OS
+	FsyncNo
 )
 
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncNo:
+		return "no"
+	default:
+		return "everysec"
+	}
+}
+
+// ParseFsyncPolicy parses an appendfsync setting, empty means everysec
+func ParseFsyncPolicy(value string) (FsyncPolicy, error) {
+	switch strings.ToLower(value) {
+	case "", "everysec":
+		return FsyncEverySec, nil
+	case "always":
+		return FsyncAlways, nil
+	case "no":
+		return FsyncNo, nil
+	default:
+		return 0, fmt.Errorf("invalid appendfsync %q, expected always, everysec or no", value)
+	}
+}
+
+// aofFile is the part of *os.File the AOF uses
+type aofFile interface {
+	io.WriteCloser
+	Sync() error
+	Stat() (os.FileInfo, error)
+}
+
+// AOF appends commands to a file, or to the last part of a multi-part AOF.
+// Writers queue commands and a single goroutine writes everything queued so
+// far in one batch, with one fsync under FsyncAlways, so concurrent clients
+// share the cost of the disk.
 type AOF struct {
-	file *os.File
-	mu   sync.Mutex
+	filename string
+	// Directory of a multi-part AOF, empty for a single file
+	dir    string
+	policy FsyncPolicy
+	// Rewrites start the file with a snapshot instead of commands
+	preamble atomic.Bool
+	// Prefix every command with a timestamp and checksum
+	annotate atomic.Bool
+	// Incremental files are rotated once they reach this size, zero never
+	maxPartSize atomic.Int64
+
+	// Held while writing to file, and while a rewrite swaps it. The file
+	// is also guarded by mu so it can be read without waiting on a write.
+	fileMu sync.Mutex
+	file   aofFile
+	// Parts of a multi-part AOF, the size of the one being appended to and
+	// the number of the next one, guarded by fileMu
+	manifest Manifest
+	partSize int64
+	nextPart int
+	// Held during fsync so a rewrite doesn't close the file under it
+	syncMu sync.Mutex
+	// Mutating commands hold it for reading from applying the change to
+	// queueing it, so a rewrite can snapshot the storage at an exact
+	// position in the AOF
+	applyMu sync.RWMutex
+
+	mu sync.Mutex
+	// Signalled when commands are queued or the AOF is closed
+	queued sync.Cond
+	// Broadcast when a batch is done
+	done  sync.Cond
+	queue [][]byte
+	// Sequence numbers of the last queued command and of the last one
+	// written, and fsynced under FsyncAlways
+	lastQueued  uint64
+	lastWritten uint64
+	// A failed batch leaves the file in an unknown state, so every later
+	// command fails too
+	err    error
+	closed bool
+	// Writes not yet fsynced, for everysec
+	dirty   bool
+	rewrite rewriteState
+	// Size and checksum of what has been written to file
+	position AOFPosition
+
+	writer sync.WaitGroup
+	stop   chan struct{}
+	syncer sync.WaitGroup
 }
 
 func NewAOF(filename string) *AOF {
+	return NewAOFWithPolicy(filename, FsyncEverySec)
+}
+
+func NewAOFWithPolicy(filename string, policy FsyncPolicy) *AOF {
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatalf("Failed to open AOF file: %v", err)
 	}
-	return &AOF{
-		file: file,
+	position, err := filePosition(filename)
+	if err != nil {
+		log.Fatalf("Failed to read AOF file: %v", err)
+	}
+	a := newAOF(file, policy, time.Second)
+	a.filename = filename
+	a.position = position
+	a.rewrite.baseSize = position.Offset
+	return a
+}
+
+// NewMultiPartAOF appends to the multi-part AOF in dir, creating it if
+// needed, see Manifest
+func NewMultiPartAOF(dir string, policy FsyncPolicy) *AOF {
+	manifest, file, err := openParts(dir)
+	if err != nil {
+		log.Fatalf("Failed to open AOF directory: %v", err)
+	}
+	position, partSize, err := partsPosition(dir, manifest)
+	if err != nil {
+		log.Fatalf("Failed to read AOF directory: %v", err)
+	}
+	a := newAOF(file, policy, time.Second)
+	a.dir = dir
+	a.manifest = manifest
+	a.partSize = partSize
+	a.nextPart = manifest.next()
+	a.position = position
+	a.rewrite.baseSize = position.Offset
+	return a
+}
+
+func newAOF(file aofFile, policy FsyncPolicy, syncInterval time.Duration) *AOF {
+	a := &AOF{
+		file:   file,
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+	a.queued.L = &a.mu
+	a.done.L = &a.mu
+	a.writer.Go(a.writeBatches)
+	if policy == FsyncEverySec {
+		a.syncer.Go(func() {
+			a.syncEvery(syncInterval)
+		})
 	}
+	return a
+}
+
+func (a *AOF) Policy() FsyncPolicy {
+	return a.policy
 }
 
+// SetSnapshotPreamble makes rewrites write the data as a binary snapshot
+// followed by the commands that come after it, which replays much faster
+func (a *AOF) SetSnapshotPreamble(enabled bool) {
+	a.preamble.Store(enabled)
+}
+
+func (a *AOF) SnapshotPreamble() bool {
+	return a.preamble.Load()
+}
+
+// SetAnnotations makes every command appended from now on carry the time it
+// was written and a checksum, see Annotate
+func (a *AOF) SetAnnotations(enabled bool) {
+	a.annotate.Store(enabled)
+}
+
+func (a *AOF) Annotations() bool {
+	return a.annotate.Load()
+}
+
+// SetMaxPartSize makes a multi-part AOF move on to a new incremental file
+// once the current one reaches size bytes, zero leaves it to rewrites
+func (a *AOF) SetMaxPartSize(size int64) {
+	a.maxPartSize.Store(size)
+}
+
+// MultiPart reports whether the AOF is a multi-part directory
+func (a *AOF) MultiPart() bool {
+	return a.dir != ""
+}
+
+// Append writes a command to the AOF and waits for it to be written, and
+// fsynced under FsyncAlways
 func (a *AOF) Append(command []byte) error {
+	seq, err := a.Enqueue(command)
+	if err != nil {
+		return err
+	}
+	return a.Wait(seq)
+}
+
+// Checkpoint calls snapshot while no command is being applied and returns
+// the position in the AOF matching the state it saw. Writes are held up
+// until the commands already queued are written.
+func (a *AOF) Checkpoint(snapshot func()) (AOFPosition, error) {
+	a.applyMu.Lock()
+	defer a.applyMu.Unlock()
+
+	snapshot()
+	a.mu.Lock()
+	seq := a.lastQueued
+	a.mu.Unlock()
+	if err := a.Wait(seq); err != nil {
+		return AOFPosition{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.position, nil
+}
+
+// BeginApply is called by mutating commands before they change the
+// storage, and EndApply once the command is queued
+func (a *AOF) BeginApply() {
+	a.applyMu.RLock()
+}
+
+func (a *AOF) EndApply() {
+	a.applyMu.RUnlock()
+}
+
+// Enqueue queues a command without waiting for the disk. The returned
+// sequence number is passed to Wait before the command is acknowledged.
+func (a *AOF) Enqueue(command []byte) (uint64, error) {
+	if a.annotate.Load() {
+		command = Annotate(command, time.Now())
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	_, err := a.file.Write(command)
+
+	if a.closed {
+		return 0, os.ErrClosed
+	}
+	if a.err != nil {
+		return 0, a.err
+	}
+	a.queue = append(a.queue, command)
+	a.lastQueued++
+	a.queued.Signal()
+	return a.lastQueued, nil
+}
+
+// Err returns why no more commands can be written, if that's the case
+func (a *AOF) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return os.ErrClosed
+	}
+	return a.err
+}
+
+// Wait blocks until the command with sequence number seq, and every one
+// before it, has been written
+func (a *AOF) Wait(seq uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.lastWritten < seq && a.err == nil {
+		a.done.Wait()
+	}
+	if a.lastWritten >= seq {
+		return nil
+	}
+	return a.err
+}
+
+// writeBatches writes queued commands until the AOF is closed and the
+// queue is drained
+func (a *AOF) writeBatches() {
+	var buffer []byte
+	for {
+		a.mu.Lock()
+		for len(a.queue) == 0 && !a.closed {
+			a.queued.Wait()
+		}
+		if len(a.queue) == 0 {
+			a.mu.Unlock()
+			return
+		}
+		batch := a.queue
+		last := a.lastQueued
+		a.queue = nil
+		a.mu.Unlock()
+
+		buffer = buffer[:0]
+		for _, command := range batch {
+			buffer = append(buffer, command...)
+		}
+		batchSize.Observe(float64(len(batch)))
+
+		a.fileMu.Lock()
+		err := a.writeBatch(buffer)
+		a.mu.Lock()
+		if err != nil {
+			log.Printf("Failed to write AOF batch of %d commands: %v", len(batch), err)
+			a.err = err
+		} else {
+			a.lastWritten = last
+			a.position = a.position.advance(buffer)
+			if a.policy == FsyncEverySec {
+				a.dirty = true
+			}
+			a.rewrite.record(batch, last)
+		}
+		a.mu.Unlock()
+		a.done.Broadcast()
+
+		if err == nil && a.dir != "" {
+			a.partSize += int64(len(buffer))
+			if max := a.maxPartSize.Load(); max > 0 && a.partSize >= max {
+				if err := a.rotate(); err != nil {
+					// Writes carry on in the current part
+					log.Printf("Failed to start a new AOF part: %v", err)
+				}
+			}
+		}
+		a.fileMu.Unlock()
+	}
+}
+
+// rotate moves a multi-part AOF on to a new incremental file. Called with
+// fileMu held.
+func (a *AOF) rotate() error {
+	name := partName(incrPart, a.nextPart)
+	file, err := os.OpenFile(filepath.Join(a.dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	a.nextPart++
+	manifest := Manifest{Base: a.manifest.Base, Incrs: append(slices.Clone(a.manifest.Incrs), name)}
+
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	// Replay reads straight on into the new part, so nothing may be
+	// missing from the end of the old one after a crash
+	err = a.file.Sync()
+	if err == nil {
+		err = writeManifest(a.dir, manifest)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(filepath.Join(a.dir, name))
+		return err
+	}
+
+	a.mu.Lock()
+	old := a.file
+	a.file = file
+	a.dirty = false
+	a.mu.Unlock()
+	a.manifest = manifest
+	a.partSize = 0
+	return old.Close()
+}
+
+func (a *AOF) writeBatch(buffer []byte) error {
+	start := time.Now()
+	_, err := a.file.Write(buffer)
+	writeDuration.ObserveDuration(time.Since(start))
+	if err != nil {
+		writeErrors.Inc()
+		return err
+	}
+	if a.policy == FsyncAlways {
+		return a.sync()
+	}
+	return nil
+}
+
+// syncEvery fsyncs pending writes every interval until Close. The fsync
+// runs without the lock so writers aren't blocked behind the disk.
+func (a *AOF) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			dirty := a.dirty
+			a.dirty = false
+			a.mu.Unlock()
+
+			if !dirty {
+				continue
+			}
+			if err := a.sync(); err != nil {
+				log.Printf("Failed to fsync AOF: %v", err)
+				// Try again on the next tick
+				a.mu.Lock()
+				a.dirty = true
+				a.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (a *AOF) sync() error {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	a.mu.Lock()
+	file := a.file
+	a.mu.Unlock()
+
+	start := time.Now()
+	err := file.Sync()
+	fsyncDuration.ObserveDuration(time.Since(start))
+	if err != nil {
+		fsyncErrors.Inc()
+	}
 	return err
 }
 
+// Close writes the commands still queued, fsyncs and closes the file
 func (a *AOF) Close() error {
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	if err := a.file.Sync(); err != nil {
+	alreadyClosed := a.closed
+	a.closed = true
+	a.queued.Signal()
+	a.mu.Unlock()
+
+	a.writer.Wait()
+	if !alreadyClosed {
+		close(a.stop)
+	}
+	a.syncer.Wait()
+
+	// A rewrite finishing now sees the AOF closed and keeps the old file
+	a.fileMu.Lock()
+	defer a.fileMu.Unlock()
+	if err := a.sync(); err != nil {
 		return err
 	}
 	return a.file.Close()
 }
+
+// Size returns the current size of the AOF file in bytes, of every part
+// together for a multi-part AOF
+func (a *AOF) Size() (int64, error) {
+	a.mu.Lock()
+	file := a.file
+	position := a.position
+	a.mu.Unlock()
+
+	if a.dir != "" {
+		return position.Offset, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
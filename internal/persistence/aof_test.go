@@ -152,6 +152,22 @@ func TestAppend_LargeData(t *testing.T) {
 	}
 }
 
+func TestSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test.aof")
+
+	aof := NewAOF(filename)
+	defer aof.Close()
+
+	if size, err := aof.Size(); err != nil || size != 0 {
+		t.Fatalf("Expected empty AOF, got %d, %v", size, err)
+	}
+	aof.Append([]byte("test data"))
+	if size, err := aof.Size(); err != nil || size != 9 {
+		t.Fatalf("Expected 9 bytes, got %d, %v", size, err)
+	}
+}
+
 func TestClose(t *testing.T) {
 	tmpDir := t.TempDir()
 	filename := filepath.Join(tmpDir, "test.aof")
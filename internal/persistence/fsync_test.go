@@ -0,0 +1,207 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFile simulates a disk: written bytes only survive a crash once Sync
+// has returned
+type memFile struct {
+	mu      sync.Mutex
+	written []byte
+	durable int
+	syncs   int
+	syncErr error
+	closed  bool
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	f.written = append(f.written, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncs++
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	f.durable = len(f.written)
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return nil, errors.New("not supported")
+}
+
+// crash returns what would be on disk after a power loss
+func (f *memFile) crash() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return string(f.written[:f.durable])
+}
+
+func (f *memFile) syncCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.syncs
+}
+
+func (f *memFile) setSyncErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncErr = err
+}
+
+func TestFsyncAlways_DurableBeforeAppendReturns(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncAlways, time.Hour)
+	defer aof.Close()
+
+	expected := ""
+	for _, command := range []string{"SET a 1\n", "SET b 2\n", "RPUSH c 3\n"} {
+		if err := aof.Append([]byte(command)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		expected += command
+		if got := file.crash(); got != expected {
+			t.Fatalf("Expected %q to survive a crash, got %q", expected, got)
+		}
+	}
+	if syncs := file.syncCount(); syncs != 3 {
+		t.Fatalf("Expected one fsync per append, got %d", syncs)
+	}
+}
+
+func TestFsyncAlways_Error(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncAlways, time.Hour)
+	defer aof.Close()
+
+	file.setSyncErr(errors.New("disk full"))
+	if err := aof.Append([]byte("SET a 1\n")); err == nil {
+		t.Fatal("Append should report the fsync failure")
+	}
+}
+
+func TestFsyncEverySec_DurableAfterInterval(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncEverySec, 10*time.Millisecond)
+	defer aof.Close()
+
+	for range 1000 {
+		aof.Append([]byte("x"))
+	}
+	waitForDurable(t, file, 1000)
+
+	// Appends are batched into far fewer fsyncs
+	syncs := file.syncCount()
+	if syncs >= 100 {
+		t.Fatalf("Expected batched fsyncs, got %d for 1000 appends", syncs)
+	}
+
+	// Nothing to flush, nothing synced
+	time.Sleep(50 * time.Millisecond)
+	if idle := file.syncCount(); idle != syncs {
+		t.Fatalf("Expected no fsync while idle, got %d more", idle-syncs)
+	}
+}
+
+func TestFsyncEverySec_RetriesAfterError(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncEverySec, 10*time.Millisecond)
+	defer aof.Close()
+
+	file.setSyncErr(errors.New("disk full"))
+	aof.Append([]byte("SET a 1\n"))
+	for file.syncCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if got := file.crash(); got != "" {
+		t.Fatalf("Nothing should be durable yet, got %q", got)
+	}
+
+	file.setSyncErr(nil)
+	waitForDurable(t, file, len("SET a 1\n"))
+}
+
+func TestFsyncNo_OnlyOnClose(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncNo, 10*time.Millisecond)
+
+	aof.Append([]byte("SET a 1\n"))
+	time.Sleep(50 * time.Millisecond)
+	if syncs := file.syncCount(); syncs != 0 {
+		t.Fatalf("Expected no fsync, got %d", syncs)
+	}
+
+	if err := aof.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := file.crash(); got != "SET a 1\n" {
+		t.Fatalf("Expected Close to fsync, got %q", got)
+	}
+}
+
+func TestClose_EverySecFlushesPending(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncEverySec, time.Hour)
+
+	aof.Append([]byte("SET a 1\n"))
+	if err := aof.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := file.crash(); got != "SET a 1\n" {
+		t.Fatalf("Expected Close to fsync, got %q", got)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	testCases := map[string]FsyncPolicy{
+		"":         FsyncEverySec,
+		"everysec": FsyncEverySec,
+		"ALWAYS":   FsyncAlways,
+		"no":       FsyncNo,
+	}
+	for value, expected := range testCases {
+		policy, err := ParseFsyncPolicy(value)
+		if err != nil || policy != expected {
+			t.Errorf("ParseFsyncPolicy(%q): expected %v, got %v, %v", value, expected, policy, err)
+		}
+		if value != "" && policy.String() != strings.ToLower(value) {
+			t.Errorf("Expected %q, got %q", strings.ToLower(value), policy.String())
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Fatal("Expected an error for an invalid policy")
+	}
+}
+
+func waitForDurable(t *testing.T, file *memFile, size int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(file.crash()) != size {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d durable bytes, got %d", size, len(file.crash()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
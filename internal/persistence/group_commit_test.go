@@ -0,0 +1,133 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowFile is a memFile whose fsync takes a while, like a real disk
+type slowFile struct {
+	memFile
+	syncDelay time.Duration
+}
+
+func (f *slowFile) Sync() error {
+	time.Sleep(f.syncDelay)
+	return f.memFile.Sync()
+}
+
+func TestGroupCommit_BatchesConcurrentWriters(t *testing.T) {
+	file := &slowFile{syncDelay: 5 * time.Millisecond}
+	aof := newAOF(file, FsyncAlways, time.Hour)
+	defer aof.Close()
+
+	const writers, perWriter = 20, 10
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Go(func() {
+			for i := range perWriter {
+				command := fmt.Sprintf("SET %d %d\n", w, i)
+				if err := aof.Append([]byte(command)); err != nil {
+					t.Errorf("Append failed: %v", err)
+					return
+				}
+				// Acknowledged means durable
+				if !strings.Contains(file.crash(), command) {
+					t.Errorf("%q acknowledged before it was durable", command)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if syncs := file.syncCount(); syncs >= writers*perWriter/2 {
+		t.Fatalf("Expected commands to share fsyncs, got %d fsyncs for %d commands", syncs, writers*perWriter)
+	}
+	if lines := strings.Count(file.crash(), "\n"); lines != writers*perWriter {
+		t.Fatalf("Expected %d commands on disk, got %d", writers*perWriter, lines)
+	}
+}
+
+func TestEnqueue_Wait(t *testing.T) {
+	file := &slowFile{syncDelay: 20 * time.Millisecond}
+	aof := newAOF(file, FsyncAlways, time.Hour)
+	defer aof.Close()
+
+	first, _ := aof.Enqueue([]byte("SET a 1\n"))
+	second, err := aof.Enqueue([]byte("SET b 2\n"))
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if second != first+1 {
+		t.Fatalf("Expected consecutive sequence numbers, got %d and %d", first, second)
+	}
+
+	if err := aof.Wait(second); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	// Waiting for a command covers every one queued before it
+	if got := file.crash(); got != "SET a 1\nSET b 2\n" {
+		t.Fatalf("Expected both commands to be durable, got %q", got)
+	}
+	if err := aof.Wait(first); err != nil {
+		t.Fatalf("Wait for an earlier command failed: %v", err)
+	}
+}
+
+func TestGroupCommit_ErrorIsSticky(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncAlways, time.Hour)
+	defer aof.Close()
+
+	file.setSyncErr(errors.New("disk full"))
+	if err := aof.Append([]byte("SET a 1\n")); err == nil {
+		t.Fatal("Expected Append to fail")
+	}
+	if aof.Err() == nil {
+		t.Fatal("Expected the AOF to report the error")
+	}
+
+	// The disk recovering doesn't make the file trustworthy again
+	file.setSyncErr(nil)
+	if _, err := aof.Enqueue([]byte("SET b 2\n")); err == nil {
+		t.Fatal("Expected Enqueue to fail after an error")
+	}
+}
+
+func TestClose_WritesQueuedCommands(t *testing.T) {
+	file := &memFile{}
+	aof := newAOF(file, FsyncNo, time.Hour)
+
+	for i := range 100 {
+		aof.Enqueue(fmt.Appendf(nil, "SET %d x\n", i))
+	}
+	if err := aof.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if lines := strings.Count(file.crash(), "\n"); lines != 100 {
+		t.Fatalf("Expected 100 commands on disk, got %d", lines)
+	}
+	if aof.Err() == nil {
+		t.Fatal("Expected a closed AOF to report an error")
+	}
+}
+
+func BenchmarkAppend_Always(b *testing.B) {
+	aof := NewAOFWithPolicy(filepath.Join(b.TempDir(), "bench.aof"), FsyncAlways)
+	defer aof.Close()
+	command := []byte("*3\r\n$5\r\nRPUSH\r\n$4\r\nchat\r\n$5\r\nhello\r\n")
+
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := aof.Append(command); err != nil {
+				b.Fatalf("Append failed: %v", err)
+			}
+		}
+	})
+}
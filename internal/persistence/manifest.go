@@ -0,0 +1,136 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A multi-part AOF is a directory holding a base file, written by the last
+// rewrite, and the incremental files appended to since. The manifest lists
+// them in replay order:
+//
+//	base base.3.aof
+//	incr incr.4.aof
+//	incr incr.5.aof
+//
+// It's replaced atomically whenever the parts change, so the directory
+// always describes a complete AOF. Files it doesn't list are leftovers.
+const ManifestName = "manifest"
+
+const (
+	basePart = "base"
+	incrPart = "incr"
+)
+
+// Manifest lists the parts of a multi-part AOF, by file name within its
+// directory
+type Manifest struct {
+	Base  string // empty until the first rewrite
+	Incrs []string
+}
+
+// Files returns every part in replay order
+func (m Manifest) Files() []string {
+	var files []string
+	if m.Base != "" {
+		files = append(files, m.Base)
+	}
+	return append(files, m.Incrs...)
+}
+
+// next returns the number for a new part, above every listed one
+func (m Manifest) next() int {
+	next := 1
+	for _, name := range m.Files() {
+		if n, ok := partNumber(name); ok && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+func partName(kind string, n int) string {
+	return fmt.Sprintf("%s.%d.aof", kind, n)
+}
+
+func partNumber(name string) (int, bool) {
+	fields := strings.Split(name, ".")
+	if len(fields) != 3 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(fields[1])
+	return n, err == nil
+}
+
+// ReadManifest reads the manifest of the multi-part AOF in dir
+func ReadManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return manifest, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		// Parts are plain names, nothing outside dir is ever read
+		if len(fields) != 2 || filepath.Base(fields[1]) != fields[1] || fields[1] == ManifestName {
+			return manifest, fmt.Errorf("invalid AOF manifest line %d: %q", line, scanner.Text())
+		}
+		switch fields[0] {
+		case basePart:
+			if manifest.Base != "" || len(manifest.Incrs) > 0 {
+				return manifest, fmt.Errorf("invalid AOF manifest line %d: the base must come first, once", line)
+			}
+			manifest.Base = fields[1]
+		case incrPart:
+			manifest.Incrs = append(manifest.Incrs, fields[1])
+		default:
+			return manifest, fmt.Errorf("invalid AOF manifest line %d: unknown part type %q", line, fields[0])
+		}
+	}
+	return manifest, scanner.Err()
+}
+
+// writeManifest replaces the manifest in dir, which takes effect all at
+// once when the new one is renamed over it
+func writeManifest(dir string, manifest Manifest) error {
+	var data bytes.Buffer
+	if manifest.Base != "" {
+		fmt.Fprintf(&data, "%s %s\n", basePart, manifest.Base)
+	}
+	for _, name := range manifest.Incrs {
+		fmt.Fprintf(&data, "%s %s\n", incrPart, name)
+	}
+
+	filename := filepath.Join(dir, ManifestName)
+	tempName := filename + ".tmp"
+	file, err := os.OpenFile(tempName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempName, filename)
+	}
+	if err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
@@ -0,0 +1,204 @@
+package persistence
+
+import (
+	"errors"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// IsMultiPart reports whether path is the directory of a multi-part AOF
+func IsMultiPart(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// MigrateToMultiPart turns the single-file AOF at path into the base of a
+// multi-part one in its place. Nothing is done if path isn't a file.
+func MigrateToMultiPart(path string) error {
+	// The directory is put together next to the file and renamed over it
+	// last, so an interrupted migration is either redone or finished here
+	tempDir := path + ".migrate"
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := ReadManifest(tempDir); err == nil {
+			return os.Rename(tempDir, path)
+		}
+		return nil
+	}
+	if err != nil || info.IsDir() {
+		return err
+	}
+
+	if err := os.RemoveAll(tempDir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tempDir, 0755); err != nil {
+		return err
+	}
+	manifest := Manifest{Base: partName(basePart, 1)}
+	if err := writeManifest(tempDir, manifest); err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(tempDir, manifest.Base)); err != nil {
+		return err
+	}
+	if err := os.Rename(tempDir, path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// AOFReader reads an AOF as a single stream, be it a plain file or every
+// part of a multi-part one in order. Offsets in it, such as AOFPosition,
+// count from the start of the first part.
+type AOFReader struct {
+	io.Reader
+	files []*os.File
+	size  int64
+}
+
+// OpenAOF opens the AOF at path, a file or a multi-part directory
+func OpenAOF(path string) (*AOFReader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{path}
+	if info.IsDir() {
+		manifest, err := ReadManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, name := range manifest.Files() {
+			names = append(names, filepath.Join(path, name))
+		}
+	}
+
+	r := &AOFReader{}
+	readers := make([]io.Reader, 0, len(names))
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err == nil {
+			info, err = file.Stat()
+			r.files = append(r.files, file)
+		}
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.size += info.Size()
+		readers = append(readers, file)
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// Size returns the size of every part together
+func (r *AOFReader) Size() int64 {
+	return r.size
+}
+
+func (r *AOFReader) Close() error {
+	var errs []error
+	for _, file := range r.files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// TruncateAOF cuts the AOF at path down to size bytes. The parts of a
+// multi-part AOF past that point are dropped.
+func TruncateAOF(path string, size int64) error {
+	if !IsMultiPart(path) {
+		return os.Truncate(path, size)
+	}
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	files := manifest.Files()
+	offset := int64(0)
+	for i, name := range files {
+		info, err := os.Stat(filepath.Join(path, name))
+		if err != nil {
+			return err
+		}
+		if offset+info.Size() < size && i < len(files)-1 {
+			offset += info.Size()
+			continue
+		}
+
+		if size-offset < info.Size() {
+			if err := os.Truncate(filepath.Join(path, name), size-offset); err != nil {
+				return err
+			}
+		}
+		if i == len(files)-1 {
+			return nil
+		}
+		kept := Manifest{Base: manifest.Base}
+		if manifest.Base != "" {
+			kept.Incrs = files[1 : i+1]
+		} else {
+			kept.Incrs = files[:i+1]
+		}
+		if err := writeManifest(path, kept); err != nil {
+			return err
+		}
+		for _, name := range files[i+1:] {
+			os.Remove(filepath.Join(path, name))
+		}
+		return nil
+	}
+	return nil
+}
+
+// openParts opens the last incremental file of the multi-part AOF in dir
+// for appending, creating the directory and a first part as needed
+func openParts(dir string) (Manifest, *os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Manifest{}, nil, err
+	}
+	manifest, err := ReadManifest(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil, err
+	}
+	if len(manifest.Incrs) == 0 {
+		manifest.Incrs = []string{partName(incrPart, manifest.next())}
+		if err := writeManifest(dir, manifest); err != nil {
+			return manifest, nil, err
+		}
+	}
+
+	name := filepath.Join(dir, manifest.Incrs[len(manifest.Incrs)-1])
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return manifest, file, err
+}
+
+// partsPosition returns the position at the end of the parts listed in
+// manifest, and the size of the last one
+func partsPosition(dir string, manifest Manifest) (AOFPosition, int64, error) {
+	var position AOFPosition
+	checksum := crc64.New(crcTable)
+	var last int64
+	for _, name := range manifest.Files() {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return position, 0, err
+		}
+		last, err = io.Copy(checksum, file)
+		file.Close()
+		if err != nil {
+			return position, 0, err
+		}
+		position.Offset += last
+	}
+	position.Checksum = checksum.Sum64()
+	return position, last, nil
+}
@@ -0,0 +1,246 @@
+package persistence
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAOF returns everything in the AOF at path as one stream
+func readAOF(t *testing.T, path string) string {
+	t.Helper()
+	r, err := OpenAOF(path)
+	if err != nil {
+		t.Fatalf("OpenAOF failed: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read AOF: %v", err)
+	}
+	if int64(len(data)) != r.Size() {
+		t.Fatalf("Expected size %d, got %d", len(data), r.Size())
+	}
+	return string(data)
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := Manifest{Base: "base.3.aof", Incrs: []string{"incr.4.aof", "incr.5.aof"}}
+	if err := writeManifest(dir, manifest); err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+	read, err := ReadManifest(dir)
+	if err != nil || !reflect.DeepEqual(read, manifest) {
+		t.Fatalf("Expected %+v, got %+v, %v", manifest, read, err)
+	}
+	if next := read.next(); next != 6 {
+		t.Fatalf("Expected the next part to be 6, got %d", next)
+	}
+
+	for _, data := range []string{
+		"base ../escape.aof\n",
+		"incr incr.1.aof\nbase base.2.aof\n",
+		"snapshot dump.snap\n",
+		"incr\n",
+	} {
+		os.WriteFile(filepath.Join(dir, ManifestName), []byte(data), 0644)
+		if _, err := ReadManifest(dir); err == nil {
+			t.Fatalf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestMultiPartAOF_Rotate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "aof")
+	aof := NewMultiPartAOF(dir, FsyncEverySec)
+	aof.SetMaxPartSize(20)
+
+	var expected strings.Builder
+	for i := range 10 {
+		command := fmt.Sprintf("SET key %d\n", i)
+		if err := aof.Append([]byte(command)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		expected.WriteString(command)
+	}
+
+	// Every part but the last one is full
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	if manifest.Base != "" || len(manifest.Incrs) < 4 || manifest.Incrs[0] != "incr.1.aof" {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+	for _, name := range manifest.Incrs[:len(manifest.Incrs)-1] {
+		if info, _ := os.Stat(filepath.Join(dir, name)); info.Size() < 20 {
+			t.Fatalf("Expected %s to be rotated only once full, got %d bytes", name, info.Size())
+		}
+	}
+	if data := readAOF(t, dir); data != expected.String() {
+		t.Fatalf("Expected %q, got %q", expected.String(), data)
+	}
+	if size, _ := aof.Size(); size != int64(expected.Len()) {
+		t.Fatalf("Expected size %d, got %d", expected.Len(), size)
+	}
+
+	// Positions span every part
+	position, err := aof.Checkpoint(func() {})
+	if err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	aof.Close()
+	// The last batch may have been rotated after it was acknowledged
+	manifest, _ = ReadManifest(dir)
+	r, _ := OpenAOF(dir)
+	matched, err := SeekPosition(r, position)
+	r.Close()
+	if !matched || err != nil {
+		t.Fatalf("Expected the position to match, got %v, %v", matched, err)
+	}
+
+	// Reopening carries on in the last part
+	aof = NewMultiPartAOF(dir, FsyncEverySec)
+	aof.Append([]byte("SET other 1\n"))
+	aof.Close()
+	if reopened, _ := ReadManifest(dir); !reflect.DeepEqual(reopened, manifest) {
+		t.Fatalf("Expected the manifest to be unchanged, got %+v", reopened)
+	}
+	if data := readAOF(t, dir); data != expected.String()+"SET other 1\n" {
+		t.Fatalf("Unexpected AOF after reopening: %q", data)
+	}
+}
+
+func TestMultiPartAOF_Rewrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "aof")
+	aof := NewMultiPartAOF(dir, FsyncEverySec)
+	defer aof.Close()
+	aof.SetMaxPartSize(20)
+
+	for i := range 10 {
+		aof.Append([]byte(fmt.Sprintf("SET key %d\n", i)))
+	}
+	old, _ := ReadManifest(dir)
+
+	err := aof.Rewrite(func() func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, "SET key 9\n")
+			return err
+		}
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if err := aof.Append([]byte("SET other 1\n")); err != nil {
+		t.Fatalf("Append after rewrite failed: %v", err)
+	}
+
+	manifest, _ := ReadManifest(dir)
+	if manifest.Base == "" || len(manifest.Incrs) != 1 {
+		t.Fatalf("Expected a base and one incremental file, got %+v", manifest)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, manifest.Base)); string(data) != "SET key 9\n" {
+		t.Fatalf("Unexpected base file %q", data)
+	}
+	if data := readAOF(t, dir); data != "SET key 9\nSET other 1\n" {
+		t.Fatalf("Unexpected AOF after rewrite: %q", data)
+	}
+	for _, name := range old.Files() {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected the old part %s to be removed, got %v", name, err)
+		}
+	}
+	if status := aof.RewriteStatus(); status.BaseSize != int64(len("SET key 9\n")) {
+		t.Fatalf("Expected base size %d, got %d", len("SET key 9\n"), status.BaseSize)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Fatalf("Expected the manifest and two parts, got %v", entries)
+	}
+}
+
+func TestMigrateToMultiPart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof := NewAOF(path)
+	aof.Append([]byte("SET key 1\n"))
+	aof.Append([]byte("SET key 2\n"))
+	before := aof.position
+	aof.Close()
+
+	if err := MigrateToMultiPart(path); err != nil {
+		t.Fatalf("MigrateToMultiPart failed: %v", err)
+	}
+	if !IsMultiPart(path) {
+		t.Fatal("Expected the AOF to be a directory")
+	}
+	if manifest, _ := ReadManifest(path); manifest.Base != "base.1.aof" || len(manifest.Incrs) != 0 {
+		t.Fatalf("Expected the old file as the only base, got %+v", manifest)
+	}
+	// Already migrated
+	if err := MigrateToMultiPart(path); err != nil {
+		t.Fatalf("MigrateToMultiPart on a directory failed: %v", err)
+	}
+
+	aof = NewMultiPartAOF(path, FsyncEverySec)
+	if position := aof.position; position != before {
+		t.Fatalf("Expected the position to carry over as %+v, got %+v", before, position)
+	}
+	aof.Append([]byte("SET key 3\n"))
+	aof.Close()
+	if data := readAOF(t, path); data != "SET key 1\nSET key 2\nSET key 3\n" {
+		t.Fatalf("Unexpected AOF after migration: %q", data)
+	}
+}
+
+func TestMigrateToMultiPart_Interrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	os.WriteFile(path, []byte("SET key 1\n"), 0644)
+
+	// Stopped before the file was moved, the migration is redone
+	os.Mkdir(path+".migrate", 0755)
+	os.WriteFile(filepath.Join(path+".migrate", "stale"), []byte("x"), 0644)
+	if err := MigrateToMultiPart(path); err != nil {
+		t.Fatalf("MigrateToMultiPart failed: %v", err)
+	}
+	if data := readAOF(t, path); data != "SET key 1\n" {
+		t.Fatalf("Unexpected AOF after migration: %q", data)
+	}
+
+	// Stopped after, the directory is moved into place
+	os.Rename(path, path+".migrate")
+	if err := MigrateToMultiPart(path); err != nil {
+		t.Fatalf("MigrateToMultiPart failed: %v", err)
+	}
+	if data := readAOF(t, path); data != "SET key 1\n" {
+		t.Fatalf("Unexpected AOF after finishing the migration: %q", data)
+	}
+	if entries, _ := os.ReadDir(path); len(entries) != 2 {
+		t.Fatalf("Expected the manifest and the base, got %v", entries)
+	}
+}
+
+func TestTruncateAOF_MultiPart(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(dir, Manifest{Base: "base.1.aof", Incrs: []string{"incr.2.aof", "incr.3.aof"}})
+	os.WriteFile(filepath.Join(dir, "base.1.aof"), []byte("base\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "incr.2.aof"), []byte("first\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "incr.3.aof"), []byte("second\n"), 0644)
+
+	if err := TruncateAOF(dir, int64(len("base\nfir"))); err != nil {
+		t.Fatalf("TruncateAOF failed: %v", err)
+	}
+	if data := readAOF(t, dir); data != "base\nfir" {
+		t.Fatalf("Unexpected AOF after truncating: %q", data)
+	}
+	if manifest, _ := ReadManifest(dir); len(manifest.Incrs) != 1 {
+		t.Fatalf("Expected the last part to be dropped, got %+v", manifest)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "incr.3.aof")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the dropped part to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"hash/crc64"
+	"io"
+	"os"
+)
+
+// AOFPosition identifies a point in the AOF by its offset and the checksum
+// of everything before it. A snapshot taken at a position can be completed
+// by replaying the AOF from there, as long as the AOF still starts with the
+// same data.
+type AOFPosition struct {
+	Offset   int64
+	Checksum uint64 // crc64 (ECMA)
+}
+
+func (p AOFPosition) advance(data []byte) AOFPosition {
+	return AOFPosition{
+		Offset:   p.Offset + int64(len(data)),
+		Checksum: crc64.Update(p.Checksum, crcTable, data),
+	}
+}
+
+// filePosition returns the position at the end of a file
+func filePosition(filename string) (AOFPosition, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return AOFPosition{}, err
+	}
+	defer file.Close()
+
+	checksum := crc64.New(crcTable)
+	n, err := io.Copy(checksum, file)
+	return AOFPosition{Offset: n, Checksum: checksum.Sum64()}, err
+}
+
+// SeekPosition reads r up to position and reports whether it holds the
+// same data as when position was taken. On success r is left right after
+// it, ready to replay what follows.
+func SeekPosition(r io.Reader, position AOFPosition) (bool, error) {
+	checksum := crc64.New(crcTable)
+	n, err := io.CopyN(checksum, r, position.Offset)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return n == position.Offset && checksum.Sum64() == position.Checksum, nil
+}
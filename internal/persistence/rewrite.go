@@ -0,0 +1,250 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+// rewriteState tracks a rewrite, guarded by AOF.mu
+type rewriteState struct {
+	inProgress bool
+	// Commands after this sequence number aren't in the snapshot and are
+	// buffered to be appended to the new file
+	from    uint64
+	buffer  []byte
+	lastErr error
+	lastAt  time.Time
+	// Size of the AOF after the last rewrite, or when it was opened
+	baseSize int64
+}
+
+// record buffers the commands of a batch that the snapshot doesn't cover
+func (r *rewriteState) record(batch [][]byte, last uint64) {
+	if !r.inProgress {
+		return
+	}
+	first := last - uint64(len(batch)) + 1
+	for i, command := range batch {
+		if first+uint64(i) > r.from {
+			r.buffer = append(r.buffer, command...)
+		}
+	}
+}
+
+// RewriteStatus describes the last rewrite, for INFO
+type RewriteStatus struct {
+	InProgress bool
+	LastErr    error
+	LastAt     time.Time // zero if there was none
+	BaseSize   int64
+}
+
+func (a *AOF) RewriteStatus() RewriteStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return RewriteStatus{
+		InProgress: a.rewrite.inProgress,
+		LastErr:    a.rewrite.lastErr,
+		LastAt:     a.rewrite.lastAt,
+		BaseSize:   a.rewrite.baseSize,
+	}
+}
+
+// Rewrite replaces the AOF with a compact one. snapshot is called while no
+// command is being applied and returns a function writing the commands
+// that rebuild that state. It runs while new commands keep being appended
+// to the old file, and those are copied over before the files are swapped.
+// A multi-part AOF gets a new base file instead, followed by a new
+// incremental file holding those commands.
+func (a *AOF) Rewrite(snapshot func() func(w io.Writer) error) error {
+	if a.filename == "" && a.dir == "" {
+		return errors.New("AOF has no file name")
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return os.ErrClosed
+	}
+	if a.rewrite.inProgress {
+		a.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+	a.rewrite.inProgress = true
+	a.mu.Unlock()
+
+	err := a.rewriteFile(snapshot)
+
+	a.mu.Lock()
+	a.rewrite.inProgress = false
+	a.rewrite.buffer = nil
+	a.rewrite.lastErr = err
+	a.rewrite.lastAt = time.Now()
+	a.mu.Unlock()
+	return err
+}
+
+func (a *AOF) rewriteFile(snapshot func() func(w io.Writer) error) error {
+	a.applyMu.Lock()
+	write := snapshot()
+	a.mu.Lock()
+	from := a.lastQueued
+	a.rewrite.from = from
+	a.rewrite.buffer = nil
+	a.mu.Unlock()
+	a.applyMu.Unlock()
+
+	tempName := a.filename + ".rewrite.tmp"
+	if a.dir != "" {
+		a.fileMu.Lock()
+		tempName = filepath.Join(a.dir, partName(basePart, a.nextPart))
+		a.nextPart++
+		a.fileMu.Unlock()
+	}
+	temp, err := os.OpenFile(tempName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			temp.Close()
+			os.Remove(tempName)
+		}
+	}()
+
+	checksum := crc64.New(crcTable)
+	writer := bufio.NewWriter(io.MultiWriter(temp, checksum))
+	if err := write(writer); err != nil {
+		return fmt.Errorf("failed to write rewritten AOF: %w", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	// Most of the data goes to disk before the writer is paused for the
+	// swap
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+
+	// Commands covered by the snapshot must not reach the new file
+	if err := a.Wait(from); err != nil {
+		return err
+	}
+
+	// No batch is written while the files are swapped
+	a.fileMu.Lock()
+	defer a.fileMu.Unlock()
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+
+	a.mu.Lock()
+	closed, buffered := a.closed, a.rewrite.buffer
+	a.mu.Unlock()
+	if closed {
+		return os.ErrClosed
+	}
+	if a.dir != "" {
+		if err := a.swapParts(temp, buffered, checksum.Sum64()); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	}
+
+	if _, err := temp.Write(buffered); err != nil {
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempName, a.filename); err != nil {
+		return err
+	}
+	swapped = true
+	syncDir(filepath.Dir(a.filename))
+
+	size := int64(0)
+	if info, err := temp.Stat(); err == nil {
+		size = info.Size()
+	}
+	position := AOFPosition{Offset: size - int64(len(buffered)), Checksum: checksum.Sum64()}.advance(buffered)
+
+	a.mu.Lock()
+	old := a.file
+	a.file = temp
+	a.position = position
+	a.rewrite.baseSize = size
+	a.mu.Unlock()
+	// Anything written to the old file is also in the new one
+	old.Close()
+	return nil
+}
+
+// swapParts makes base, just written by a rewrite, the base of the
+// multi-part AOF, followed by a new incremental file starting with the
+// commands buffered during the rewrite. Called with fileMu and syncMu held.
+func (a *AOF) swapParts(base *os.File, buffered []byte, checksum uint64) error {
+	info, err := base.Stat()
+	base.Close()
+	if err != nil {
+		return err
+	}
+
+	name := partName(incrPart, a.nextPart)
+	filename := filepath.Join(a.dir, name)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	a.nextPart++
+	_, err = file.Write(buffered)
+	if err == nil {
+		err = file.Sync()
+	}
+	manifest := Manifest{Base: filepath.Base(base.Name()), Incrs: []string{name}}
+	if err == nil {
+		err = writeManifest(a.dir, manifest)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(filename)
+		return err
+	}
+
+	position := AOFPosition{Offset: info.Size(), Checksum: checksum}.advance(buffered)
+	a.mu.Lock()
+	old := a.file
+	a.file = file
+	a.position = position
+	a.rewrite.baseSize = position.Offset
+	a.mu.Unlock()
+	old.Close()
+
+	// The manifest no longer lists the old parts
+	for _, name := range a.manifest.Files() {
+		os.Remove(filepath.Join(a.dir, name))
+	}
+	a.manifest = manifest
+	a.partSize = int64(len(buffered))
+	return nil
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,195 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := NewAOF(filename)
+	defer aof.Close()
+
+	for i := range 10 {
+		if err := aof.Append([]byte(fmt.Sprintf("SET key %d\n", i))); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	err := aof.Rewrite(func() func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, "SET key 9\n")
+			return err
+		}
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if err := aof.Append([]byte("SET other 1\n")); err != nil {
+		t.Fatalf("Append after rewrite failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filename)
+	if string(data) != "SET key 9\nSET other 1\n" {
+		t.Fatalf("Unexpected AOF after rewrite: %q", data)
+	}
+	status := aof.RewriteStatus()
+	if status.InProgress || status.LastErr != nil || status.LastAt.IsZero() {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	if status.BaseSize != int64(len("SET key 9\n")) {
+		t.Fatalf("Expected base size %d, got %d", len("SET key 9\n"), status.BaseSize)
+	}
+	if _, err := os.Stat(filename + ".rewrite.tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temp file to be gone, got %v", err)
+	}
+}
+
+func TestRewrite_KeepsConcurrentWrites(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := NewAOF(filename)
+	defer aof.Close()
+
+	// Commands applied before the snapshot are covered by it, the rest
+	// must be copied from the old file
+	var mu sync.Mutex
+	applied := 0
+	apply := func(i int) error {
+		aof.BeginApply()
+		mu.Lock()
+		applied = i + 1
+		mu.Unlock()
+		seq, err := aof.Enqueue([]byte(fmt.Sprintf("INCR %d\n", i)))
+		aof.EndApply()
+		if err != nil {
+			return err
+		}
+		return aof.Wait(seq)
+	}
+
+	const total = 500
+	var writers sync.WaitGroup
+	started := make(chan struct{})
+	writers.Go(func() {
+		for i := range total {
+			if i == total/10 {
+				close(started)
+			}
+			if err := apply(i); err != nil {
+				t.Errorf("Append failed: %v", err)
+				return
+			}
+		}
+	})
+
+	<-started
+	err := aof.Rewrite(func() func(w io.Writer) error {
+		mu.Lock()
+		snapshot := applied
+		mu.Unlock()
+		return func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "SNAPSHOT %d\n", snapshot)
+			return err
+		}
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	writers.Wait()
+
+	data, _ := os.ReadFile(filename)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var snapshot int
+	if _, err := fmt.Sscanf(lines[0], "SNAPSHOT %d", &snapshot); err != nil {
+		t.Fatalf("Expected the snapshot first, got %q", lines[0])
+	}
+	for i, line := range lines[1:] {
+		if want := fmt.Sprintf("INCR %d", snapshot+i); line != want {
+			t.Fatalf("Expected %q after the snapshot, got %q", want, line)
+		}
+	}
+	if len(lines)-1+snapshot != total {
+		t.Fatalf("Expected %d commands after the snapshot of %d, got %d", total-snapshot, snapshot, len(lines)-1)
+	}
+}
+
+func TestRewrite_InProgress(t *testing.T) {
+	aof := NewAOF(filepath.Join(t.TempDir(), "test.aof"))
+	defer aof.Close()
+
+	writing := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- aof.Rewrite(func() func(w io.Writer) error {
+			return func(w io.Writer) error {
+				close(writing)
+				<-release
+				return nil
+			}
+		})
+	}()
+
+	<-writing
+	if !aof.RewriteStatus().InProgress {
+		t.Fatalf("Expected a rewrite in progress")
+	}
+	err := aof.Rewrite(func() func(w io.Writer) error {
+		return func(w io.Writer) error { return nil }
+	})
+	if !errors.Is(err, ErrRewriteInProgress) {
+		t.Fatalf("Expected ErrRewriteInProgress, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+}
+
+func TestRewrite_WriteError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := NewAOF(filename)
+	defer aof.Close()
+	aof.Append([]byte("SET key value\n"))
+
+	failure := errors.New("boom")
+	err := aof.Rewrite(func() func(w io.Writer) error {
+		return func(w io.Writer) error { return failure }
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+	if status := aof.RewriteStatus(); !errors.Is(status.LastErr, failure) {
+		t.Fatalf("Expected the error in the status, got %v", status.LastErr)
+	}
+
+	// The old file is still in use
+	if err := aof.Append([]byte("SET key other\n")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+	if string(data) != "SET key value\nSET key other\n" {
+		t.Fatalf("Expected the AOF to be untouched, got %q", data)
+	}
+	if _, err := os.Stat(filename + ".rewrite.tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temp file to be removed, got %v", err)
+	}
+}
+
+func TestRewrite_Closed(t *testing.T) {
+	aof := NewAOF(filepath.Join(t.TempDir(), "test.aof"))
+	aof.Close()
+
+	err := aof.Rewrite(func() func(w io.Writer) error {
+		return func(w io.Writer) error { return nil }
+	})
+	if !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Expected os.ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// A snapshot file is laid out as:
+//
+//	"KVSNAP" version(1 byte) created-at(varint, unix ms) flags(1 byte)
+//	[aof-offset(uvarint) aof-checksum(8 bytes)]   if flags has snapshotHasAOF
+//	entries: type(1 byte) expires-at(varint, unix ms, 0 if none) key value
+//	snapshotEOF(1 byte) crc64 of everything before it(8 bytes)
+//
+// Strings are a uvarint length followed by the bytes, lists a uvarint
+// element count followed by the elements. Integers are little endian.
+const (
+	snapshotMagic   = "KVSNAP"
+	snapshotVersion = 1
+
+	snapshotHasAOF = 1 << 0
+
+	snapshotString = 0
+	snapshotList   = 1
+	snapshotEOF    = 0xff
+
+	// Guards allocations against lengths read from a corrupted file
+	maxSnapshotLength = 512 * 1024 * 1024
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+// SnapshotHeader describes when a snapshot was taken
+type SnapshotHeader struct {
+	CreatedAt time.Time
+	// Where the AOF stood when the snapshot was taken, nil if it was
+	// disabled
+	AOF *AOFPosition
+}
+
+// WriteSnapshot writes data in the snapshot format
+func WriteSnapshot(w io.Writer, header SnapshotHeader, data map[string]store.Value) error {
+	checksum := crc64.New(crcTable)
+	writer := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	buffer := append([]byte(snapshotMagic), snapshotVersion)
+	buffer = binary.AppendVarint(buffer, header.CreatedAt.UnixMilli())
+	if header.AOF != nil {
+		buffer = append(buffer, snapshotHasAOF)
+		buffer = binary.AppendUvarint(buffer, uint64(header.AOF.Offset))
+		buffer = binary.LittleEndian.AppendUint64(buffer, header.AOF.Checksum)
+	} else {
+		buffer = append(buffer, 0)
+	}
+	writer.Write(buffer)
+
+	for key, value := range data {
+		buffer = buffer[:0]
+		switch value.Kind {
+		case store.StringType:
+			buffer = append(buffer, snapshotString)
+		case store.ListType:
+			buffer = append(buffer, snapshotList)
+		default:
+			return fmt.Errorf("can't save value of type %d", value.Kind)
+		}
+		expiresAt := int64(0)
+		if !value.ExpiresAt.IsZero() {
+			expiresAt = value.ExpiresAt.UnixMilli()
+		}
+		buffer = binary.AppendVarint(buffer, expiresAt)
+		buffer = appendSnapshotString(buffer, []byte(key))
+		if value.Kind == store.StringType {
+			buffer = appendSnapshotString(buffer, value.Str)
+		} else {
+			buffer = binary.AppendUvarint(buffer, uint64(len(value.List)))
+			for _, element := range value.List {
+				buffer = appendSnapshotString(buffer, element)
+			}
+		}
+		if _, err := writer.Write(buffer); err != nil {
+			return err
+		}
+	}
+
+	writer.WriteByte(snapshotEOF)
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.LittleEndian.AppendUint64(nil, checksum.Sum64()))
+	return err
+}
+
+func appendSnapshotString(buffer, value []byte) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// snapshotReader checksums everything read through it
+type snapshotReader struct {
+	reader   *bufio.Reader
+	checksum uint64
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.checksum = crc64.Update(r.checksum, crcTable, []byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.checksum = crc64.Update(r.checksum, crcTable, p[:n])
+	return n, err
+}
+
+func (r *snapshotReader) readLength() (int, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if length > maxSnapshotLength {
+		return 0, fmt.Errorf("invalid length %d", length)
+	}
+	return int(length), nil
+}
+
+func (r *snapshotReader) readString() ([]byte, error) {
+	length, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// IsSnapshot tells whether reader is positioned at the start of a
+// snapshot, without consuming anything
+func IsSnapshot(reader *bufio.Reader) bool {
+	magic, err := reader.Peek(len(snapshotMagic))
+	return err == nil && string(magic) == snapshotMagic
+}
+
+// ReadSnapshot reads a snapshot, passing every entry to load. Entries are
+// loaded as they are read, so the caller must discard them if the
+// snapshot turns out to be corrupted. Nothing past the end of the snapshot
+// is consumed from reader.
+func ReadSnapshot(reader *bufio.Reader, load func(key string, value store.Value)) (SnapshotHeader, error) {
+	var header SnapshotHeader
+	r := &snapshotReader{reader: reader}
+
+	magic := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return header, errors.New("not a snapshot file")
+	}
+	if version := magic[len(snapshotMagic)]; version != snapshotVersion {
+		return header, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	createdAt, err := binary.ReadVarint(r)
+	if err != nil {
+		return header, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	header.CreatedAt = time.UnixMilli(createdAt)
+	flags, err := r.ReadByte()
+	if err != nil {
+		return header, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if flags&snapshotHasAOF != 0 {
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return header, fmt.Errorf("failed to read snapshot header: %w", err)
+		}
+		var checksum [8]byte
+		if _, err := io.ReadFull(r, checksum[:]); err != nil {
+			return header, fmt.Errorf("failed to read snapshot header: %w", err)
+		}
+		header.AOF = &AOFPosition{Offset: int64(offset), Checksum: binary.LittleEndian.Uint64(checksum[:])}
+	}
+
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return header, fmt.Errorf("failed to read snapshot entry: %w", err)
+		}
+		if kind == snapshotEOF {
+			break
+		}
+		key, value, err := r.readEntry(kind)
+		if err != nil {
+			return header, fmt.Errorf("failed to read snapshot entry: %w", err)
+		}
+		load(key, value)
+	}
+
+	expected := r.checksum
+	var checksum [8]byte
+	if _, err := io.ReadFull(reader, checksum[:]); err != nil {
+		return header, fmt.Errorf("failed to read snapshot checksum: %w", err)
+	}
+	if binary.LittleEndian.Uint64(checksum[:]) != expected {
+		return header, ErrSnapshotChecksum
+	}
+	return header, nil
+}
+
+func (r *snapshotReader) readEntry(kind byte) (string, store.Value, error) {
+	var value store.Value
+	expiresAt, err := binary.ReadVarint(r)
+	if err != nil {
+		return "", value, err
+	}
+	if expiresAt != 0 {
+		value.ExpiresAt = time.UnixMilli(expiresAt)
+	}
+	key, err := r.readString()
+	if err != nil {
+		return "", value, err
+	}
+
+	switch kind {
+	case snapshotString:
+		value.Kind = store.StringType
+		value.Str, err = r.readString()
+	case snapshotList:
+		value.Kind = store.ListType
+		var length int
+		length, err = r.readLength()
+		for i := 0; i < length && err == nil; i++ {
+			var element []byte
+			element, err = r.readString()
+			value.List = append(value.List, element)
+		}
+	default:
+		err = fmt.Errorf("unknown value type %d", kind)
+	}
+	return string(key), value, err
+}
+
+// SaveSnapshot writes a snapshot to filename, replacing it only once the
+// new one is safely on disk
+func SaveSnapshot(filename string, header SnapshotHeader, data map[string]store.Value) error {
+	tempName := filename + ".tmp"
+	file, err := os.OpenFile(tempName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := WriteSnapshot(file, header, data); err != nil {
+		file.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	if err := os.Rename(tempName, filename); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+// LoadSnapshot reads the snapshot in filename, see ReadSnapshot
+func LoadSnapshot(filename string, load func(key string, value store.Value)) (SnapshotHeader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return SnapshotHeader{}, err
+	}
+	defer file.Close()
+
+	return ReadSnapshot(bufio.NewReader(file), load)
+}
@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func testSnapshotData() map[string]store.Value {
+	return map[string]store.Value{
+		"greeting": {Kind: store.StringType, Str: []byte("hello")},
+		"empty":    {Kind: store.StringType, Str: []byte{}},
+		"binary":   {Kind: store.StringType, Str: []byte("a\x00\r\n\xff")},
+		"chat":     {Kind: store.ListType, List: [][]byte{[]byte("first"), []byte("second")}},
+		"session":  {Kind: store.StringType, Str: []byte("token"), ExpiresAt: time.UnixMilli(4102444800123)},
+	}
+}
+
+func readTestSnapshot(t *testing.T, data []byte) (SnapshotHeader, map[string]store.Value, error) {
+	t.Helper()
+	loaded := map[string]store.Value{}
+	header, err := ReadSnapshot(bufio.NewReader(bytes.NewReader(data)), func(key string, value store.Value) {
+		loaded[key] = value
+	})
+	return header, loaded, err
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	data := testSnapshotData()
+	header := SnapshotHeader{
+		CreatedAt: time.UnixMilli(1700000000456),
+		AOF:       &AOFPosition{Offset: 1234, Checksum: 0xdeadbeef},
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteSnapshot(&buffer, header, data); err != nil {
+		t.Fatalf("WriteSnapshot failed: %v", err)
+	}
+	got, loaded, err := readTestSnapshot(t, buffer.Bytes())
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	if !got.CreatedAt.Equal(header.CreatedAt) || got.AOF == nil || *got.AOF != *header.AOF {
+		t.Fatalf("Expected header %+v, got %+v", header, got)
+	}
+	for key, value := range loaded {
+		// Compare instants, not monotonic clock readings or locations
+		if !value.ExpiresAt.IsZero() {
+			if !value.ExpiresAt.Equal(data[key].ExpiresAt) {
+				t.Fatalf("Expected %s to expire at %v, got %v", key, data[key].ExpiresAt, value.ExpiresAt)
+			}
+			value.ExpiresAt = data[key].ExpiresAt
+			loaded[key] = value
+		}
+	}
+	if !reflect.DeepEqual(loaded, data) {
+		t.Fatalf("Expected %v, got %v", data, loaded)
+	}
+}
+
+func TestSnapshot_WithoutAOF(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteSnapshot(&buffer, SnapshotHeader{CreatedAt: time.Now()}, nil); err != nil {
+		t.Fatalf("WriteSnapshot failed: %v", err)
+	}
+	header, loaded, err := readTestSnapshot(t, buffer.Bytes())
+	if err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	if header.AOF != nil || len(loaded) != 0 {
+		t.Fatalf("Expected an empty snapshot without AOF position, got %+v and %v", header, loaded)
+	}
+}
+
+func TestSnapshot_Corrupted(t *testing.T) {
+	var buffer bytes.Buffer
+	WriteSnapshot(&buffer, SnapshotHeader{CreatedAt: time.Now()}, testSnapshotData())
+	data := buffer.Bytes()
+
+	flipped := bytes.Clone(data)
+	index := bytes.Index(flipped, []byte("hello"))
+	flipped[index] = 'j'
+	if _, _, err := readTestSnapshot(t, flipped); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Fatalf("Expected a checksum error, got %v", err)
+	}
+
+	for _, size := range []int{0, 5, len(data) / 2, len(data) - 1} {
+		if _, _, err := readTestSnapshot(t, data[:size]); err == nil {
+			t.Fatalf("Expected an error for a snapshot truncated to %d bytes", size)
+		}
+	}
+
+	newer := bytes.Clone(data)
+	newer[len(snapshotMagic)] = snapshotVersion + 1
+	if _, _, err := readTestSnapshot(t, newer); err == nil {
+		t.Fatal("Expected an error for an unknown version")
+	}
+}
+
+func TestReadSnapshot_LeavesTrailingData(t *testing.T) {
+	var buffer bytes.Buffer
+	WriteSnapshot(&buffer, SnapshotHeader{CreatedAt: time.Now()}, testSnapshotData())
+	buffer.WriteString("*1\r\n$4\r\nPING\r\n")
+
+	reader := bufio.NewReader(&buffer)
+	if !IsSnapshot(reader) {
+		t.Fatal("Expected the reader to be at a snapshot")
+	}
+	if _, err := ReadSnapshot(reader, func(string, store.Value) {}); err != nil {
+		t.Fatalf("ReadSnapshot failed: %v", err)
+	}
+	rest, _ := reader.ReadString(0)
+	if rest != "*1\r\n$4\r\nPING\r\n" {
+		t.Fatalf("Expected the data after the snapshot to be left, got %q", rest)
+	}
+	if IsSnapshot(bufio.NewReader(bytes.NewReader([]byte(rest)))) {
+		t.Fatal("Expected RESP not to look like a snapshot")
+	}
+}
+
+func TestSaveSnapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.kvs")
+	if err := SaveSnapshot(filename, SnapshotHeader{CreatedAt: time.Now()}, testSnapshotData()); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temp file to be gone, got %v", err)
+	}
+
+	count := 0
+	if _, err := LoadSnapshot(filename, func(string, store.Value) { count++ }); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if count != len(testSnapshotData()) {
+		t.Fatalf("Expected %d entries, got %d", len(testSnapshotData()), count)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	os.WriteFile(filename, []byte("SET a 1\n"), 0644)
+	aof := NewAOF(filename)
+	defer aof.Close()
+
+	aof.Append([]byte("SET b 2\n"))
+	position, err := aof.Checkpoint(func() {})
+	if err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	aof.Append([]byte("SET c 3\n"))
+
+	file, _ := os.Open(filename)
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	matched, err := SeekPosition(reader, position)
+	if err != nil || !matched {
+		t.Fatalf("Expected the AOF to match the checkpoint, got %v, %v", matched, err)
+	}
+	if rest, _ := reader.ReadString(0); rest != "SET c 3\n" {
+		t.Fatalf("Expected to be positioned after the checkpoint, got %q", rest)
+	}
+
+	// Once rewritten, the old position no longer matches
+	aof.Rewrite(func() func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, "SET c 3\nSET a 1\nSET b 2\n")
+			return err
+		}
+	})
+	rewritten, _ := os.Open(filename)
+	defer rewritten.Close()
+	if matched, _ := SeekPosition(rewritten, position); matched {
+		t.Fatal("Expected the rewritten AOF not to match")
+	}
+	aof.Append([]byte("SET d 4\n"))
+	current, _ := aof.Checkpoint(func() {})
+	rewritten.Seek(0, io.SeekStart)
+	if matched, _ := SeekPosition(rewritten, current); !matched {
+		t.Fatal("Expected the rewritten AOF to match a new checkpoint")
+	}
+}
@@ -3,10 +3,13 @@ package protocol
 import (
 	"log"
 	"strings"
+	"time"
 
 	"github.com/flash10042/kv-chat/internal/commands"
+	"github.com/flash10042/kv-chat/internal/metrics"
 	"github.com/flash10042/kv-chat/internal/persistence"
 	"github.com/flash10042/kv-chat/internal/response"
+	"github.com/flash10042/kv-chat/internal/slowlog"
 	"github.com/flash10042/kv-chat/internal/store"
 )
 
@@ -17,7 +20,15 @@ const (
 	DispatchModePrivate
 )
 
-func checkArity(length int, arity int) bool {
+// Recorded for client commands only, not for AOF replay
+var (
+	commandCalls = metrics.Default.CounterVec("kvchat_commands_total",
+		"Commands processed by the dispatcher.", "cmd")
+	commandDuration = metrics.Default.HistogramVec("kvchat_command_duration_seconds",
+		"Time spent executing commands, excluding the AOF write.", "cmd", metrics.DefaultBuckets)
+)
+
+func CheckArity(length int, arity int) bool {
 	if arity >= 0 && length != arity {
 		return false
 	}
@@ -28,38 +39,88 @@ func checkArity(length int, arity int) bool {
 }
 
 func DispatchCommand(dispatchMode DispatchMode, args [][]byte, storage *store.Storage, aof *persistence.AOF) string {
+	response, _ := DispatchCommandWithOptions(dispatchMode, args, storage, aof, DispatchOptions{})
+	return response
+}
+
+// DispatchOptions carries what the server knows about the caller
+type DispatchOptions struct {
+	// Slow commands are recorded here on behalf of Client
+	SlowLog *slowlog.Log
+	Client  slowlog.Client
+	// Queue the AOF write instead of waiting for it. The caller must pass
+	// the returned sequence number to AOF.Wait before replying.
+	AsyncAOF bool
+}
+
+// DispatchCommandWithOptions is DispatchCommand for client connections. It
+// also returns the AOF sequence number of the command, 0 if it wasn't
+// queued.
+func DispatchCommandWithOptions(dispatchMode DispatchMode, args [][]byte, storage *store.Storage, aof *persistence.AOF, options DispatchOptions) (string, uint64) {
 	if len(args) == 0 {
-		return response.ErrEmptyCommandResponse()
+		return response.ErrEmptyCommandResponse(), 0
 	}
 
 	name := strings.ToUpper(string(args[0]))
 	command, ok := commands.Registry[name]
 	if !ok {
-		return response.ErrUnknownCommandResponse()
+		return response.ErrUnknownCommandResponse(string(args[0])), 0
 	}
 
 	if command.IsPrivate && dispatchMode != DispatchModePrivate {
-		return response.ErrUnknownCommandResponse()
+		return response.ErrUnknownCommandResponse(string(args[0])), 0
+	}
+
+	if !CheckArity(len(args), command.Arity) {
+		return response.ErrWrongArityResponse(name), 0
 	}
 
-	if !checkArity(len(args), command.Arity) {
-		return response.ErrWrongArityResponse()
+	persist := dispatchMode == DispatchModePublic && command.Mutates && aof != nil
+	if persist {
+		// Don't accept writes that can't be persisted
+		if err := aof.Err(); err != nil {
+			return response.ErrAOFWriteResponse(err), 0
+		}
+		aof.BeginApply()
 	}
 
 	// Ideally, handler wouldn't return a bool, but we need it since validation is integrated into handler
-	response, ok := command.Handler(args, storage)
+	start := time.Now()
+	reply, ok := command.Handler(args, storage)
+	duration := time.Since(start)
 
-	if ok && dispatchMode == DispatchModePublic && command.Mutates && aof != nil {
+	var seq uint64
+	var err error
+	if persist && ok {
 		// Use AOFTransform if available, otherwise use original args
 		aofArgs := args
 		if command.AOFTransform != nil {
 			aofArgs = command.AOFTransform(args)
 		}
-		err := aof.Append(EncodeCommand(aofArgs))
-		if err != nil {
-			log.Printf("Failed to append command to AOF: %v", err)
+		seq, err = aof.Enqueue(EncodeCommand(aofArgs))
+	}
+	if persist {
+		aof.EndApply()
+	}
+	if err == nil && seq != 0 && !options.AsyncAOF {
+		err = aof.Wait(seq)
+		seq = 0
+	}
+	if err != nil {
+		// The change is applied but not persisted, which mustn't be
+		// acknowledged
+		log.Printf("Failed to append command to AOF: %v", err)
+		reply = response.ErrAOFWriteResponse(err)
+	}
+
+	if dispatchMode == DispatchModePublic {
+		label := strings.ToLower(name)
+		commandCalls.With(label).Inc()
+		commandDuration.With(label).ObserveDuration(duration)
+		if options.SlowLog != nil {
+			options.SlowLog.Record(args, duration, options.Client)
 		}
 	}
 
-	return response
+	return reply, seq
 }
@@ -3,8 +3,10 @@ package protocol
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/flash10042/kv-chat/internal/commands"
 	"github.com/flash10042/kv-chat/internal/persistence"
 	"github.com/flash10042/kv-chat/internal/response"
 	"github.com/flash10042/kv-chat/internal/store"
@@ -12,30 +14,30 @@ import (
 
 func TestCheckArity(t *testing.T) {
 	// Positive cases
-	if !checkArity(3, 3) {
-		t.Fatal("checkArity(3, 3) should return true")
+	if !CheckArity(3, 3) {
+		t.Fatal("CheckArity(3, 3) should return true")
 	}
-	if !checkArity(2, 2) {
-		t.Fatal("checkArity(2, 2) should return true")
+	if !CheckArity(2, 2) {
+		t.Fatal("CheckArity(2, 2) should return true")
 	}
 
 	// Negative cases - exact mismatch
-	if checkArity(2, 3) {
-		t.Fatal("checkArity(2, 3) should return false")
+	if CheckArity(2, 3) {
+		t.Fatal("CheckArity(2, 3) should return false")
 	}
-	if checkArity(3, 2) {
-		t.Fatal("checkArity(3, 2) should return false")
+	if CheckArity(3, 2) {
+		t.Fatal("CheckArity(3, 2) should return false")
 	}
 
 	// Variable arity (negative means at least)
-	if !checkArity(3, -2) {
-		t.Fatal("checkArity(3, -2) should return true (3 >= 2)")
+	if !CheckArity(3, -2) {
+		t.Fatal("CheckArity(3, -2) should return true (3 >= 2)")
 	}
-	if !checkArity(5, -3) {
-		t.Fatal("checkArity(5, -3) should return true (5 >= 3)")
+	if !CheckArity(5, -3) {
+		t.Fatal("CheckArity(5, -3) should return true (5 >= 3)")
 	}
-	if checkArity(1, -2) {
-		t.Fatal("checkArity(1, -2) should return false (1 < 2)")
+	if CheckArity(1, -2) {
+		t.Fatal("CheckArity(1, -2) should return false (1 < 2)")
 	}
 }
 
@@ -57,7 +59,7 @@ func TestDispatchCommand_UnknownCommand(t *testing.T) {
 
 	result := DispatchCommand(DispatchModePublic, args, storage, nil)
 
-	expected := response.ErrUnknownCommandResponse()
+	expected := response.ErrUnknownCommandResponse("UNKNOWN")
 	if result != expected {
 		t.Fatalf("Expected unknown command error, got %q", result)
 	}
@@ -70,7 +72,7 @@ func TestDispatchCommand_WrongArity(t *testing.T) {
 
 	result := DispatchCommand(DispatchModePublic, args, storage, nil)
 
-	expected := response.ErrWrongArityResponse()
+	expected := response.ErrWrongArityResponse("SET")
 	if result != expected {
 		t.Fatalf("Expected wrong arity error, got %q", result)
 	}
@@ -374,3 +376,67 @@ func TestDispatchCommand_AllRegisteredCommands(t *testing.T) {
 		})
 	}
 }
+
+func TestDispatchCommand_Metrics(t *testing.T) {
+	storage := store.NewStorage()
+	calls := commandCalls.With("get").Value()
+	observed := commandDuration.With("get").Count()
+
+	DispatchCommand(DispatchModePublic, [][]byte{[]byte("get"), []byte("key")}, storage, nil)
+	DispatchCommand(DispatchModePrivate, [][]byte{[]byte("GET"), []byte("key")}, storage, nil)
+	DispatchCommand(DispatchModePublic, [][]byte{[]byte("GET")}, storage, nil)
+
+	// Replay and rejected commands are not counted
+	if got := commandCalls.With("get").Value() - calls; got != 1 {
+		t.Fatalf("Expected 1 call, got %d", got)
+	}
+	if got := commandDuration.With("get").Count() - observed; got != 1 {
+		t.Fatalf("Expected 1 observation, got %d", got)
+	}
+}
+
+func TestDispatchCommandWithOptions_AsyncAOF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	defer aof.Close()
+	storage := store.NewStorage()
+	options := DispatchOptions{AsyncAOF: true}
+
+	reply, seq := DispatchCommandWithOptions(DispatchModePublic, [][]byte{[]byte("SET"), []byte("key"), []byte("value")}, storage, aof, options)
+	if reply != "+OK\r\n" || seq == 0 {
+		t.Fatalf("Expected OK with a sequence number, got %q, %d", reply, seq)
+	}
+	if err := aof.Wait(seq); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != string(EncodeCommand([][]byte{[]byte("SET"), []byte("key"), []byte("value")})) {
+		t.Fatalf("Unexpected AOF contents %q", data)
+	}
+
+	// Reads aren't queued
+	if _, seq := DispatchCommandWithOptions(DispatchModePublic, [][]byte{[]byte("GET"), []byte("key")}, storage, aof, options); seq != 0 {
+		t.Fatalf("Expected no sequence number for a read, got %d", seq)
+	}
+}
+
+func TestDispatchCommand_AOFClosedAfterCheck(t *testing.T) {
+	aof := persistence.NewAOF(filepath.Join(t.TempDir(), "test.aof"))
+	storage := store.NewStorage()
+
+	// Closes the AOF after the dispatcher checked it, before the append
+	commands.Registry["CLOSEAOF"] = commands.Command{
+		Name:    "CLOSEAOF",
+		Arity:   1,
+		Mutates: true,
+		Handler: func(args [][]byte, storage *store.Storage) (string, bool) {
+			aof.Close()
+			return response.FormatResponse(response.SimpleStringPrefix, "OK"), true
+		},
+	}
+	t.Cleanup(func() { delete(commands.Registry, "CLOSEAOF") })
+
+	reply := DispatchCommand(DispatchModePublic, [][]byte{[]byte("CLOSEAOF")}, storage, aof)
+	if !strings.HasPrefix(reply, "-MISCONF Errors writing to the AOF file") {
+		t.Fatalf("Expected MISCONF, got %q", reply)
+	}
+}
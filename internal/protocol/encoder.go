@@ -5,10 +5,21 @@ import (
 	"strings"
 )
 
+// EncodeCommand encodes args as a RESP array. A nil args slice is encoded as
+// a null array and a nil arg as a null bulk string, so both survive a round
+// trip through ReadCommand.
 func EncodeCommand(args [][]byte) []byte {
 	var builder strings.Builder
+	if args == nil {
+		builder.WriteString("*-1\r\n")
+		return []byte(builder.String())
+	}
 	fmt.Fprintf(&builder, "*%d\r\n", len(args))
 	for _, arg := range args {
+		if arg == nil {
+			builder.WriteString("$-1\r\n")
+			continue
+		}
 		fmt.Fprintf(&builder, "$%d\r\n", len(arg))
 		builder.Write(arg)
 		builder.WriteString("\r\n")
@@ -176,3 +176,58 @@ func TestEncodeCommand_RoundTrip(t *testing.T) {
 	}
 }
 
+
+func TestEncodeCommand_NullArray(t *testing.T) {
+	result := EncodeCommand(nil)
+
+	expected := "*-1\r\n"
+	if string(result) != expected {
+		t.Fatalf("Expected %q, got %q", expected, string(result))
+	}
+}
+
+func TestEncodeCommand_NullArg(t *testing.T) {
+	args := [][]byte{
+		[]byte("SET"),
+		[]byte("key"),
+		nil,
+	}
+
+	result := EncodeCommand(args)
+
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$-1\r\n"
+	if string(result) != expected {
+		t.Fatalf("Expected %q, got %q", expected, string(result))
+	}
+}
+
+func TestEncodeCommand_RoundTripNulls(t *testing.T) {
+	originalArgs := [][]byte{
+		[]byte("SET"),
+		nil,
+		[]byte(""),
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(append(EncodeCommand(originalArgs), EncodeCommand(nil)...)))
+	decodedArgs, err := ReadCommand(reader)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if len(decodedArgs) != 3 {
+		t.Fatalf("Length mismatch: expected 3, got %d", len(decodedArgs))
+	}
+	if decodedArgs[1] != nil {
+		t.Fatalf("Expected null arg, got %q", decodedArgs[1])
+	}
+	if decodedArgs[2] == nil || len(decodedArgs[2]) != 0 {
+		t.Fatalf("Expected empty arg, got %q", decodedArgs[2])
+	}
+
+	decodedArgs, err = ReadCommand(reader)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decodedArgs != nil {
+		t.Fatalf("Expected null array, got %q", decodedArgs)
+	}
+}
@@ -0,0 +1,114 @@
+package protocol
+
+// splitInlineArgs splits an inline command the way redis-cli does.
+// Double-quoted strings support \n, \r, \t, \b, \a and \xHH escapes,
+// single-quoted strings are taken literally except for \'.
+func splitInlineArgs(line string) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var (
+			current       = []byte{}
+			inDoubleQuote bool
+			inSingleQuote bool
+			done          bool
+		)
+		for !done {
+			if i >= len(line) {
+				if inDoubleQuote || inSingleQuote {
+					return nil, protocolErrorf("unbalanced quotes in request")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDoubleQuote:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					current = append(current, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					current = append(current, unescapeInline(line[i]))
+				} else if c == '"' {
+					// Closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, protocolErrorf("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			case inSingleQuote:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, protocolErrorf("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			default:
+				switch {
+				case isInlineSpace(c):
+					done = true
+				case c == '"':
+					inDoubleQuote = true
+				case c == '\'':
+					inSingleQuote = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, current)
+	}
+}
+
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func unescapeInline(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
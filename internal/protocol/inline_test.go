@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSplitInlineArgs(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected []string
+	}{
+		{`SET key value`, []string{"SET", "key", "value"}},
+		{`  SET   key	value  `, []string{"SET", "key", "value"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key "line1\nline2"`, []string{"SET", "key", "line1\nline2"}},
+		{`SET key "a\r\tb"`, []string{"SET", "key", "a\r\tb"}},
+		{`SET key "\x41\x62"`, []string{"SET", "key", "Ab"}},
+		{`SET key "\x4"`, []string{"SET", "key", "x4"}},
+		{`SET key "say \"hi\""`, []string{"SET", "key", `say "hi"`}},
+		{`SET key 'it''s'`, nil},
+		{`SET key 'it\'s'`, []string{"SET", "key", "it's"}},
+		{`SET key 'no \n escapes'`, []string{"SET", "key", `no \n escapes`}},
+		{`SET key ""`, []string{"SET", "key", ""}},
+		{`SET key ''`, []string{"SET", "key", ""}},
+	}
+
+	for _, tc := range testCases {
+		args, err := splitInlineArgs(tc.line)
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("Expected error for %q, got %q", tc.line, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitInlineArgs(%q) failed: %v", tc.line, err)
+			continue
+		}
+		if len(args) != len(tc.expected) {
+			t.Errorf("splitInlineArgs(%q): expected %d args, got %q", tc.line, len(tc.expected), args)
+			continue
+		}
+		for i := range args {
+			if string(args[i]) != tc.expected[i] {
+				t.Errorf("splitInlineArgs(%q): arg %d expected %q, got %q", tc.line, i, tc.expected[i], args[i])
+			}
+		}
+	}
+}
+
+func TestSplitInlineArgs_Unbalanced(t *testing.T) {
+	lines := []string{
+		`SET key "unterminated`,
+		`SET key 'unterminated`,
+		`SET key "closed"trailing`,
+		`SET key 'closed'trailing`,
+	}
+
+	for _, line := range lines {
+		_, err := splitInlineArgs(line)
+		var protocolErr *ProtocolError
+		if !errors.As(err, &protocolErr) {
+			t.Errorf("Expected protocol error for %q, got %v", line, err)
+		}
+	}
+}
+
+func TestReadCommand_InlineQuoted(t *testing.T) {
+	input := "SET greeting \"hello world\\n\"\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	args, err := ReadCommand(reader)
+	if err != nil {
+		t.Fatalf("ReadCommand failed: %v", err)
+	}
+	if len(args) != 3 {
+		t.Fatalf("Expected 3 args, got %d", len(args))
+	}
+	if string(args[2]) != "hello world\n" {
+		t.Fatalf("Expected 'hello world\\n', got %q", args[2])
+	}
+}
@@ -2,6 +2,8 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -13,19 +15,62 @@ const (
 	bulkStringPrefix = '$'
 )
 
+// Length lines ("*3\r\n", "$5\r\n") never need more than a handful of bytes
+const maxLengthLineSize = 64
+
+// Bulk strings are read in chunks so a client announcing a huge length
+// can't make us allocate it up front
+const bulkReadChunkSize = 64 * 1024
+
+// Limits bounds how much a single command may make the reader buffer
+type Limits struct {
+	MaxArrayLength  int
+	MaxBulkLength   int
+	MaxInlineLength int
+	MaxQueryBuffer  int
+}
+
+var DefaultLimits = Limits{
+	MaxArrayLength:  1024 * 1024,
+	MaxBulkLength:   512 * 1024 * 1024,
+	MaxInlineLength: 64 * 1024,
+	MaxQueryBuffer:  1024 * 1024 * 1024,
+}
+
+// ProtocolError reports malformed or oversized input. The stream can't be
+// resynchronized after one, so the connection should be closed.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Message
+}
+
+func protocolErrorf(format string, args ...any) error {
+	return &ProtocolError{Message: fmt.Sprintf(format, args...)}
+}
+
 func ReadCommand(reader *bufio.Reader) ([][]byte, error) {
+	return ReadCommandWithLimits(reader, DefaultLimits)
+}
+
+func ReadCommandWithLimits(reader *bufio.Reader, limits Limits) ([][]byte, error) {
 	b, err := reader.Peek(1)
 	if err != nil {
 		return nil, err
 	}
 
 	if b[0] == arrayPrefix {
-		return readArray(reader)
+		return readArray(reader, limits)
 	}
 
 	// Inline command fallback
-	line, err := reader.ReadString('\n')
+	line, err := readLine(reader, limits.MaxInlineLength)
 	if err != nil {
+		if err == errLineTooLong {
+			return nil, protocolErrorf("too big inline request")
+		}
 		return nil, err
 	}
 
@@ -34,69 +79,104 @@ func ReadCommand(reader *bufio.Reader) ([][]byte, error) {
 		return nil, fmt.Errorf("empty command")
 	}
 
-	parts := strings.Fields(line)
-	args := make([][]byte, len(parts))
-	for i, p := range parts {
-		args[i] = []byte(p)
-	}
+	return splitInlineArgs(line)
+}
 
-	return args, nil
+var errLineTooLong = errors.New("line too long")
+
+func readLine(reader *bufio.Reader, maxSize int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxSize {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
 }
 
-func readArray(reader *bufio.Reader) ([][]byte, error) {
+func readLength(reader *bufio.Reader, prefix byte) (int, error) {
 	b, err := reader.ReadByte()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if b != arrayPrefix {
-		return nil, fmt.Errorf("expected array prefix, got %c", b)
+	if b != prefix {
+		return 0, protocolErrorf("expected '%c', got '%c'", prefix, b)
 	}
 
-	line, err := reader.ReadString('\n')
+	line, err := readLine(reader, maxLengthLineSize)
 	if err != nil {
-		return nil, err
+		if err == errLineTooLong {
+			return 0, protocolErrorf("invalid length line")
+		}
+		return 0, err
 	}
 	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return 0, protocolErrorf("invalid length %q", strings.TrimSpace(line))
+	}
+	return n, nil
+}
+
+func readArray(reader *bufio.Reader, limits Limits) ([][]byte, error) {
+	n, err := readLength(reader, arrayPrefix)
 	if err != nil {
 		return nil, err
 	}
 	if n < 0 {
-		return [][]byte{}, nil
+		// Null array
+		return nil, nil
+	}
+	if n > limits.MaxArrayLength {
+		return nil, protocolErrorf("invalid multibulk length")
 	}
-	values := make([][]byte, n)
-	for i := range values {
-		values[i], err = readBulkString(reader)
+
+	querySize := 0
+	values := make([][]byte, 0, min(n, 1024))
+	for range n {
+		length, err := readLength(reader, bulkStringPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			if len(values) == 0 {
+				return nil, protocolErrorf("null command name")
+			}
+			// Null bulk string, kept apart from an empty one
+			values = append(values, nil)
+			continue
+		}
+		if length > limits.MaxBulkLength {
+			return nil, protocolErrorf("invalid bulk length")
+		}
+		if querySize+length > limits.MaxQueryBuffer {
+			return nil, protocolErrorf("query buffer limit exceeded")
+		}
+		value, err := readBulkBody(reader, length)
 		if err != nil {
 			return nil, err
 		}
+		querySize += length
+		values = append(values, value)
 	}
 	return values, nil
 }
 
-func readBulkString(reader *bufio.Reader) ([]byte, error) {
-	b, err := reader.ReadByte()
-	if err != nil {
-		return nil, err
-	}
-	if b != bulkStringPrefix {
-		return nil, fmt.Errorf("expected bulk string prefix, got %c", b)
-	}
-
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, err
-	}
-	lengthStr := strings.TrimSpace(line)
-	n, err := strconv.Atoi(lengthStr)
-	if err != nil {
-		return nil, err
-	}
-	if n < 0 {
-		return []byte{}, nil
-	}
-
-	buf := make([]byte, n)
-	if _, err = io.ReadFull(reader, buf); err != nil {
+// Always returns a non-nil slice, nil is reserved for null bulk strings
+func readBulkBody(reader *bufio.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(min(n, bulkReadChunkSize))
+	if _, err := io.CopyN(&buf, reader, int64(n)); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
 	cr, err := reader.ReadByte()
@@ -108,7 +188,10 @@ func readBulkString(reader *bufio.Reader) ([]byte, error) {
 		return nil, err
 	}
 	if cr != '\r' || lf != '\n' {
-		return nil, fmt.Errorf("expected CRLF, got %c%c", cr, lf)
+		return nil, protocolErrorf("expected CRLF after bulk string")
+	}
+	if n == 0 {
+		return []byte{}, nil
 	}
-	return buf, nil
+	return buf.Bytes(), nil
 }
@@ -3,6 +3,7 @@ package protocol
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"strings"
 	"testing"
 )
@@ -92,7 +93,7 @@ func TestReadArray(t *testing.T) {
 	input := "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"
 	reader := bufio.NewReader(strings.NewReader(input))
 
-	args, err := readArray(reader)
+	args, err := readArray(reader, DefaultLimits)
 	if err != nil {
 		t.Fatalf("readArray failed: %v", err)
 	}
@@ -112,7 +113,7 @@ func TestReadArray_Empty(t *testing.T) {
 	input := "*0\r\n"
 	reader := bufio.NewReader(strings.NewReader(input))
 
-	args, err := readArray(reader)
+	args, err := readArray(reader, DefaultLimits)
 	if err != nil {
 		t.Fatalf("readArray failed: %v", err)
 	}
@@ -126,13 +127,13 @@ func TestReadArray_Negative(t *testing.T) {
 	input := "*-1\r\n"
 	reader := bufio.NewReader(strings.NewReader(input))
 
-	args, err := readArray(reader)
+	args, err := readArray(reader, DefaultLimits)
 	if err != nil {
 		t.Fatalf("readArray failed: %v", err)
 	}
 
-	if len(args) != 0 {
-		t.Fatalf("Expected 0 args for negative count, got %d", len(args))
+	if args != nil {
+		t.Fatalf("Expected null array for negative count, got %q", args)
 	}
 }
 
@@ -147,7 +148,7 @@ func TestReadArray_Large(t *testing.T) {
 
 	reader := bufio.NewReader(strings.NewReader(builder.String()))
 
-	args, err := readArray(reader)
+	args, err := readArray(reader, DefaultLimits)
 	if err != nil {
 		t.Fatalf("readArray failed: %v", err)
 	}
@@ -161,9 +162,10 @@ func TestReadArray_InvalidPrefix(t *testing.T) {
 	input := "X2\r\n"
 	reader := bufio.NewReader(strings.NewReader(input))
 
-	_, err := readArray(reader)
-	if err == nil {
-		t.Fatal("readArray should fail with invalid prefix")
+	_, err := readArray(reader, DefaultLimits)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error for invalid prefix, got %v", err)
 	}
 }
 
@@ -171,18 +173,29 @@ func TestReadArray_InvalidCount(t *testing.T) {
 	input := "*abc\r\n"
 	reader := bufio.NewReader(strings.NewReader(input))
 
-	_, err := readArray(reader)
+	_, err := readArray(reader, DefaultLimits)
 	if err == nil {
 		t.Fatal("readArray should fail with invalid count")
 	}
 }
 
-// Test readBulkString
+// readBulkString reads bulk as the argument of a command
+func readBulkString(t *testing.T, bulk string) ([]byte, error) {
+	t.Helper()
+	reader := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n" + bulk))
+	args, err := ReadCommand(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 2 {
+		t.Fatalf("Expected 2 args, got %q", args)
+	}
+	return args[1], nil
+}
+
 func TestReadBulkString(t *testing.T) {
 	input := "$5\r\nhello\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	result, err := readBulkString(reader)
+	result, err := readBulkString(t, input)
 	if err != nil {
 		t.Fatalf("readBulkString failed: %v", err)
 	}
@@ -194,29 +207,25 @@ func TestReadBulkString(t *testing.T) {
 
 func TestReadBulkString_Empty(t *testing.T) {
 	input := "$0\r\n\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	result, err := readBulkString(reader)
+	result, err := readBulkString(t, input)
 	if err != nil {
 		t.Fatalf("readBulkString failed: %v", err)
 	}
 
-	if len(result) != 0 {
-		t.Fatalf("Expected empty string, got %s", string(result))
+	if result == nil || len(result) != 0 {
+		t.Fatalf("Expected empty non-null string, got %q", result)
 	}
 }
 
 func TestReadBulkString_Negative(t *testing.T) {
 	input := "$-1\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	result, err := readBulkString(reader)
+	result, err := readBulkString(t, input)
 	if err != nil {
 		t.Fatalf("readBulkString failed: %v", err)
 	}
 
-	if len(result) != 0 {
-		t.Fatalf("Expected empty string for negative length, got %s", string(result))
+	if result != nil {
+		t.Fatalf("Expected null bulk string for negative length, got %q", result)
 	}
 }
 
@@ -224,9 +233,7 @@ func TestReadBulkString_Large(t *testing.T) {
 	// Create large string
 	largeStr := strings.Repeat("a", 10000)
 	input := "$10000\r\n" + largeStr + "\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	result, err := readBulkString(reader)
+	result, err := readBulkString(t, input)
 	if err != nil {
 		t.Fatalf("readBulkString failed: %v", err)
 	}
@@ -238,9 +245,7 @@ func TestReadBulkString_Large(t *testing.T) {
 
 func TestReadBulkString_WithNewlines(t *testing.T) {
 	input := "$12\r\nhello\r\nworld\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	result, err := readBulkString(reader)
+	result, err := readBulkString(t, input)
 	if err != nil {
 		t.Fatalf("readBulkString failed: %v", err)
 	}
@@ -252,29 +257,25 @@ func TestReadBulkString_WithNewlines(t *testing.T) {
 
 func TestReadBulkString_InvalidPrefix(t *testing.T) {
 	input := "X5\r\nhello\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	_, err := readBulkString(reader)
-	if err == nil {
-		t.Fatal("readBulkString should fail with invalid prefix")
+	_, err := readBulkString(t, input)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error for invalid prefix, got %v", err)
 	}
 }
 
 func TestReadBulkString_InvalidLength(t *testing.T) {
 	input := "$abc\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	_, err := readBulkString(reader)
-	if err == nil {
-		t.Fatal("readBulkString should fail with invalid length")
+	_, err := readBulkString(t, input)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error for invalid length, got %v", err)
 	}
 }
 
 func TestReadBulkString_MissingCRLF(t *testing.T) {
 	input := "$5\r\nhello"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	_, err := readBulkString(reader)
+	_, err := readBulkString(t, input)
 	if err == nil {
 		t.Fatal("readBulkString should fail with missing CRLF")
 	}
@@ -282,19 +283,16 @@ func TestReadBulkString_MissingCRLF(t *testing.T) {
 
 func TestReadBulkString_WrongCRLF(t *testing.T) {
 	input := "$5\r\nhello\n\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	_, err := readBulkString(reader)
-	if err == nil {
-		t.Fatal("readBulkString should fail with wrong CRLF")
+	_, err := readBulkString(t, input)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error for wrong CRLF, got %v", err)
 	}
 }
 
 func TestReadBulkString_ShortRead(t *testing.T) {
 	input := "$10\r\nshort\r\n"
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	_, err := readBulkString(reader)
+	_, err := readBulkString(t, input)
 	if err == nil {
 		t.Fatal("readBulkString should fail when data is shorter than length")
 	}
@@ -388,8 +386,7 @@ func TestReadBulkString_BinaryData(t *testing.T) {
 	buf.Write(binaryData)
 	buf.WriteString("\r\n")
 
-	reader := bufio.NewReader(&buf)
-	result, err := readBulkString(reader)
+	result, err := readBulkString(t, buf.String())
 	if err != nil {
 		t.Fatalf("readBulkString failed: %v", err)
 	}
@@ -398,3 +395,159 @@ func TestReadBulkString_BinaryData(t *testing.T) {
 		t.Fatal("Binary data read incorrectly")
 	}
 }
+
+func TestReadCommand_NullArgument(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$-1\r\n*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	args, err := ReadCommand(reader)
+	if err != nil {
+		t.Fatalf("ReadCommand failed: %v", err)
+	}
+	if len(args) != 3 || args[2] != nil {
+		t.Fatalf("Expected null third argument, got %q", args)
+	}
+
+	args, err = ReadCommand(reader)
+	if err != nil {
+		t.Fatalf("ReadCommand failed: %v", err)
+	}
+	if len(args) != 3 || args[2] == nil || len(args[2]) != 0 {
+		t.Fatalf("Expected empty third argument, got %q", args)
+	}
+}
+
+func TestReadCommand_NullCommandName(t *testing.T) {
+	input := "*2\r\n$-1\r\n$3\r\nkey\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := ReadCommand(reader)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+}
+
+// Test input limits
+func TestReadCommand_ArrayTooLong(t *testing.T) {
+	input := "*2000000000\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := ReadCommand(reader)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+}
+
+func TestReadCommand_BulkTooLong(t *testing.T) {
+	input := "*1\r\n$2000000000\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := ReadCommand(reader)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+}
+
+func TestReadCommand_InlineTooLong(t *testing.T) {
+	limits := DefaultLimits
+	limits.MaxInlineLength = 16
+	input := "SET key " + strings.Repeat("a", 100) + "\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := ReadCommandWithLimits(reader, limits)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+}
+
+func TestReadCommand_InlineLongerThanBuffer(t *testing.T) {
+	value := strings.Repeat("a", 10000)
+	input := "SET key " + value + "\n"
+	reader := bufio.NewReaderSize(strings.NewReader(input), 16)
+
+	args, err := ReadCommand(reader)
+	if err != nil {
+		t.Fatalf("ReadCommand failed: %v", err)
+	}
+	if len(args) != 3 || string(args[2]) != value {
+		t.Fatal("Long inline command read incorrectly")
+	}
+}
+
+func TestReadCommand_QueryBufferLimit(t *testing.T) {
+	limits := DefaultLimits
+	limits.MaxQueryBuffer = 10
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := ReadCommandWithLimits(reader, limits)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected protocol error, got %v", err)
+	}
+	if protocolErr.Message != "query buffer limit exceeded" {
+		t.Fatalf("Expected query buffer error, got %q", protocolErr.Message)
+	}
+}
+
+func TestReadCommand_WithinLimits(t *testing.T) {
+	limits := Limits{
+		MaxArrayLength:  3,
+		MaxBulkLength:   5,
+		MaxInlineLength: 16,
+		MaxQueryBuffer:  11,
+	}
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	args, err := ReadCommandWithLimits(reader, limits)
+	if err != nil {
+		t.Fatalf("ReadCommandWithLimits failed: %v", err)
+	}
+	if len(args) != 3 {
+		t.Fatalf("Expected 3 args, got %d", len(args))
+	}
+}
+
+func FuzzReadCommand(f *testing.F) {
+	f.Add([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+	f.Add([]byte("GET key\n"))
+	f.Add([]byte("*-1\r\n"))
+	f.Add([]byte("*1\r\n$-1\r\n"))
+	f.Add([]byte("*1\r\n$2000000000\r\n"))
+	f.Add([]byte("$5\r\nhello\r\n"))
+
+	limits := Limits{
+		MaxArrayLength:  64,
+		MaxBulkLength:   1024,
+		MaxInlineLength: 1024,
+		MaxQueryBuffer:  4096,
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		for {
+			args, err := ReadCommandWithLimits(reader, limits)
+			if err != nil {
+				return
+			}
+			if len(args) > limits.MaxArrayLength {
+				t.Fatalf("Read %d args, limit is %d", len(args), limits.MaxArrayLength)
+			}
+			total := 0
+			for _, arg := range args {
+				if len(arg) > limits.MaxBulkLength && len(arg) > limits.MaxInlineLength {
+					t.Fatalf("Read arg of %d bytes past limits", len(arg))
+				}
+				total += len(arg)
+			}
+			if total > limits.MaxQueryBuffer {
+				t.Fatalf("Read %d bytes, query buffer limit is %d", total, limits.MaxQueryBuffer)
+			}
+		}
+	})
+}
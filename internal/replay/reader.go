@@ -0,0 +1,159 @@
+package replay
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// TruncatedError reports a command cut short by the end of the file, as
+// left by a crash in the middle of a write
+type TruncatedError struct {
+	// Where the incomplete command starts, the file is valid up to there
+	Offset int64
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("AOF ends with an incomplete command at offset %d", e.Offset)
+}
+
+// CorruptedError reports data that can't be parsed before the end of the
+// file
+type CorruptedError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("AOF is corrupted at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *CorruptedError) Unwrap() error {
+	return e.Err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// Reader reads an AOF, optionally starting with a snapshot preamble,
+// keeping track of the offset of every command
+type Reader struct {
+	counter *countingReader
+	reader  *bufio.Reader
+	// Whether the AOF started with a snapshot, and when it was taken
+	preamble     bool
+	preambleTime time.Time
+}
+
+func NewReader(r io.Reader) *Reader {
+	counter := &countingReader{reader: r}
+	return &Reader{counter: counter, reader: bufio.NewReader(counter)}
+}
+
+// Offset returns how far into the file the reader is
+func (r *Reader) Offset() int64 {
+	return r.counter.count - int64(r.reader.Buffered())
+}
+
+// ReadPreamble loads the snapshot the AOF starts with, if any. It must be
+// called before the first command is read.
+func (r *Reader) ReadPreamble(load func(key string, value store.Value)) (bool, error) {
+	if !persistence.IsSnapshot(r.reader) {
+		return false, nil
+	}
+	header, err := persistence.ReadSnapshot(r.reader, load)
+	if err != nil {
+		// A preamble is written in full before the file is used, so it's
+		// never just truncated
+		return true, &CorruptedError{Offset: r.Offset(), Err: err}
+	}
+	r.preamble = true
+	r.preambleTime = header.CreatedAt
+	return true, nil
+}
+
+// Seek skips to position, if the AOF holds the same data up to there
+func (r *Reader) Seek(position persistence.AOFPosition) (bool, error) {
+	return persistence.SeekPosition(r.reader, position)
+}
+
+// Entry is a command read from the AOF
+type Entry struct {
+	Args [][]byte
+	// Where the entry starts, annotation included
+	Offset int64
+	// When it was written, zero if the entry isn't annotated
+	Time time.Time
+}
+
+// ReadEntry returns the next entry, io.EOF at the end of the file, a
+// *TruncatedError if the file ends in the middle of the entry and a
+// *CorruptedError if it can't be parsed or fails its checksum
+func (r *Reader) ReadEntry() (Entry, error) {
+	entry := Entry{Offset: r.Offset()}
+	truncated := func(err error) bool {
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	var annotation persistence.Annotation
+	annotated := false
+	if b, err := r.reader.Peek(1); err == nil && b[0] == persistence.AnnotationPrefix {
+		line, err := r.reader.ReadSlice('\n')
+		if truncated(err) {
+			return entry, &TruncatedError{Offset: entry.Offset}
+		}
+		if err == nil {
+			annotation, err = persistence.ParseAnnotation(string(line))
+		}
+		if err != nil {
+			return entry, &CorruptedError{Offset: entry.Offset, Err: err}
+		}
+		annotated = true
+		entry.Time = annotation.Time
+	}
+
+	start := r.Offset()
+	args, err := protocol.ReadCommand(r.reader)
+	if err == io.EOF && r.Offset() == start && !annotated {
+		return entry, io.EOF
+	}
+	if truncated(err) {
+		return entry, &TruncatedError{Offset: entry.Offset}
+	}
+	if err != nil {
+		return entry, &CorruptedError{Offset: entry.Offset, Err: err}
+	}
+	// Annotated entries are always written by EncodeCommand
+	if annotation.HasChecksum && persistence.EntryChecksum(protocol.EncodeCommand(args)) != annotation.Checksum {
+		return entry, &CorruptedError{Offset: entry.Offset, Err: persistence.ErrEntryChecksum}
+	}
+	entry.Args = args
+	return entry, nil
+}
+
+// ReadCommand is ReadEntry for callers that only need the command
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	entry, err := r.ReadEntry()
+	return entry.Args, err
+}
+
+// Apply runs every command left in the AOF against storage
+func (r *Reader) Apply(storage *store.Storage) error {
+	_, err := r.ApplyUntil(storage, Target{})
+	return err
+}
@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func encode(args ...string) []byte {
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	return protocol.EncodeCommand(command)
+}
+
+func TestReader_Offsets(t *testing.T) {
+	first := encode("SET", "key", "value")
+	second := encode("RPUSH", "list", "element")
+	reader := NewReader(bytes.NewReader(append(bytes.Clone(first), second...)))
+
+	if _, err := reader.ReadCommand(); err != nil {
+		t.Fatalf("ReadCommand failed: %v", err)
+	}
+	if offset := reader.Offset(); offset != int64(len(first)) {
+		t.Fatalf("Expected offset %d, got %d", len(first), offset)
+	}
+	args, err := reader.ReadCommand()
+	if err != nil || string(args[0]) != "RPUSH" {
+		t.Fatalf("Expected RPUSH, got %q, %v", args, err)
+	}
+	if _, err := reader.ReadCommand(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+// Every way a crash can cut off the last command is reported as truncated,
+// with the offset of the last complete one
+func TestReader_Truncated(t *testing.T) {
+	first := encode("SET", "key", "value")
+	second := encode("SET", "other", "value")
+	data := append(bytes.Clone(first), second...)
+
+	for size := len(first) + 1; size < len(data); size++ {
+		reader := NewReader(bytes.NewReader(data[:size]))
+		reader.ReadCommand()
+		_, err := reader.ReadCommand()
+		var truncated *TruncatedError
+		if !errors.As(err, &truncated) {
+			t.Fatalf("Size %d: expected a TruncatedError, got %v", size, err)
+		}
+		if truncated.Offset != int64(len(first)) {
+			t.Fatalf("Size %d: expected offset %d, got %d", size, len(first), truncated.Offset)
+		}
+	}
+}
+
+func TestReader_Corrupted(t *testing.T) {
+	first := encode("SET", "key", "value")
+	data := append(bytes.Clone(first), "*2\r\n$abc\r\nSET\r\n"...)
+	data = append(data, encode("SET", "other", "value")...)
+
+	reader := NewReader(bytes.NewReader(data))
+	reader.ReadCommand()
+	_, err := reader.ReadCommand()
+	var corrupted *CorruptedError
+	if !errors.As(err, &corrupted) {
+		t.Fatalf("Expected a CorruptedError, got %v", err)
+	}
+	if corrupted.Offset != int64(len(first)) {
+		t.Fatalf("Expected offset %d, got %d", len(first), corrupted.Offset)
+	}
+}
+
+func TestReader_Preamble(t *testing.T) {
+	var buffer bytes.Buffer
+	persistence.WriteSnapshot(&buffer, persistence.SnapshotHeader{CreatedAt: time.Now()}, map[string]store.Value{
+		"key": {Kind: store.StringType, Str: []byte("value")},
+	})
+	preamble := buffer.Len()
+	buffer.Write(encode("SET", "other", "value"))
+
+	storage := store.NewStorage()
+	reader := NewReader(&buffer)
+	if found, err := reader.ReadPreamble(storage.Restore); !found || err != nil {
+		t.Fatalf("Expected a preamble, got %v, %v", found, err)
+	}
+	if reader.Offset() != int64(preamble) {
+		t.Fatalf("Expected offset %d after the preamble, got %d", preamble, reader.Offset())
+	}
+	if err := reader.Apply(storage); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !storage.Exists("key") || !storage.Exists("other") {
+		t.Fatal("Expected keys from both the preamble and the commands")
+	}
+
+	plain := NewReader(bufio.NewReader(bytes.NewReader(encode("PING"))))
+	if found, err := plain.ReadPreamble(storage.Restore); found || err != nil {
+		t.Fatalf("Expected no preamble, got %v, %v", found, err)
+	}
+}
+
+func TestReader_Annotations(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+	plain := encode("SET", "key", "value")
+	annotated := persistence.Annotate(encode("RPUSH", "list", "element"), at)
+	reader := NewReader(bytes.NewReader(append(bytes.Clone(plain), annotated...)))
+
+	entry, err := reader.ReadEntry()
+	if err != nil || !entry.Time.IsZero() || entry.Offset != 0 {
+		t.Fatalf("Expected a plain entry at 0, got %+v, %v", entry, err)
+	}
+	entry, err = reader.ReadEntry()
+	if err != nil || string(entry.Args[0]) != "RPUSH" {
+		t.Fatalf("Expected RPUSH, got %+v, %v", entry, err)
+	}
+	if !entry.Time.Equal(at) || entry.Offset != int64(len(plain)) {
+		t.Fatalf("Expected time %v at offset %d, got %+v", at, len(plain), entry)
+	}
+	if _, err := reader.ReadEntry(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestReader_AnnotationChecksum(t *testing.T) {
+	first := persistence.Annotate(encode("SET", "key", "value"), time.Now())
+	second := persistence.Annotate(encode("SET", "other", "value"), time.Now())
+	// Flip a bit in the value, the command still parses
+	second[len(second)-3] ^= 0x01
+	reader := NewReader(bytes.NewReader(append(bytes.Clone(first), second...)))
+
+	if _, err := reader.ReadCommand(); err != nil {
+		t.Fatalf("ReadCommand failed: %v", err)
+	}
+	_, err := reader.ReadCommand()
+	var corrupted *CorruptedError
+	if !errors.As(err, &corrupted) || !errors.Is(err, persistence.ErrEntryChecksum) {
+		t.Fatalf("Expected a checksum error, got %v", err)
+	}
+	if corrupted.Offset != int64(len(first)) {
+		t.Fatalf("Expected offset %d, got %d", len(first), corrupted.Offset)
+	}
+}
+
+// A crash can also cut off an entry in its annotation or right after it
+func TestReader_AnnotationTruncated(t *testing.T) {
+	first := persistence.Annotate(encode("SET", "key", "value"), time.Now())
+	data := append(bytes.Clone(first), persistence.Annotate(encode("SET", "other", "value"), time.Now())...)
+
+	for size := len(first) + 1; size < len(data); size++ {
+		reader := NewReader(bytes.NewReader(data[:size]))
+		reader.ReadCommand()
+		_, err := reader.ReadCommand()
+		var truncated *TruncatedError
+		if !errors.As(err, &truncated) {
+			t.Fatalf("Size %d: expected a TruncatedError, got %v", size, err)
+		}
+		if truncated.Offset != int64(len(first)) {
+			t.Fatalf("Size %d: expected offset %d, got %d", size, len(first), truncated.Offset)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// ErrTargetBeforePreamble is returned when the AOF was rewritten after the
+// recovery target, so the state as of then is no longer in it
+var ErrTargetBeforePreamble = errors.New("the AOF starts with a snapshot taken after the recovery target")
+
+// Target is how far a point-in-time recovery replays: the entries written
+// up to Time that end by Offset. Zero fields don't limit it.
+type Target struct {
+	Time   time.Time
+	Offset int64
+}
+
+// ParseTarget builds a target from a time, in RFC 3339 or unix
+// milliseconds, and an offset. Empty and zero leave them unset.
+func ParseTarget(at string, offset int64) (Target, error) {
+	target := Target{Offset: offset}
+	if offset < 0 {
+		return target, fmt.Errorf("invalid recovery offset %d", offset)
+	}
+	if at == "" {
+		return target, nil
+	}
+	if ms, err := strconv.ParseInt(at, 10, 64); err == nil {
+		target.Time = time.UnixMilli(ms)
+		return target, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return target, fmt.Errorf("invalid recovery time %q, expected RFC 3339 or unix milliseconds", at)
+	}
+	target.Time = t
+	return target, nil
+}
+
+func (t Target) IsZero() bool {
+	return t.Time.IsZero() && t.Offset == 0
+}
+
+// includes reports whether an entry ending at end belongs to the recovery.
+// Entries without a timestamp can't be placed, they are kept until one
+// written after the target shows up.
+func (t Target) includes(entry Entry, end int64) bool {
+	if t.Offset > 0 && end > t.Offset {
+		return false
+	}
+	return t.Time.IsZero() || entry.Time.IsZero() || !entry.Time.After(t.Time)
+}
+
+// Recovered is how far ApplyUntil got
+type Recovered struct {
+	// The AOF cut off here holds exactly what was replayed
+	Offset   int64
+	Commands int
+	// Timestamp of the last command replayed, zero if it has none
+	Last time.Time
+}
+
+// ApplyUntil runs the commands written up to target against storage. A
+// truncated or corrupted entry past the target offset isn't an error, as it
+// wouldn't be replayed anyway.
+func (r *Reader) ApplyUntil(storage *store.Storage, target Target) (Recovered, error) {
+	recovered := Recovered{Offset: r.Offset()}
+	if r.preamble && (target.Offset > 0 && target.Offset < recovered.Offset ||
+		!target.Time.IsZero() && r.preambleTime.After(target.Time)) {
+		return recovered, ErrTargetBeforePreamble
+	}
+
+	for {
+		entry, err := r.ReadEntry()
+		if err == io.EOF {
+			return recovered, nil
+		}
+		if err != nil {
+			if target.Offset > 0 && errorOffset(err) >= target.Offset {
+				return recovered, nil
+			}
+			return recovered, err
+		}
+		if !target.includes(entry, r.Offset()) {
+			return recovered, nil
+		}
+		protocol.DispatchCommand(protocol.DispatchModePrivate, entry.Args, storage, nil)
+		recovered.Offset = r.Offset()
+		recovered.Commands++
+		recovered.Last = entry.Time
+	}
+}
+
+func errorOffset(err error) int64 {
+	var truncated *TruncatedError
+	if errors.As(err, &truncated) {
+		return truncated.Offset
+	}
+	var corrupted *CorruptedError
+	if errors.As(err, &corrupted) {
+		return corrupted.Offset
+	}
+	return -1
+}
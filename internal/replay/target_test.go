@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("2026-10-18T10:00:00Z", 0)
+	if err != nil || !target.Time.Equal(time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected target %+v, %v", target, err)
+	}
+	target, err = ParseTarget("1700000000123", 42)
+	if err != nil || target.Time.UnixMilli() != 1700000000123 || target.Offset != 42 {
+		t.Fatalf("Unexpected target %+v, %v", target, err)
+	}
+	if target, err := ParseTarget("", 0); err != nil || !target.IsZero() {
+		t.Fatalf("Expected an empty target, got %+v, %v", target, err)
+	}
+	for _, at := range []string{"yesterday", "2026-10-18"} {
+		if _, err := ParseTarget(at, 0); err == nil {
+			t.Fatalf("Expected an error for %q", at)
+		}
+	}
+	if _, err := ParseTarget("", -1); err == nil {
+		t.Fatal("Expected an error for a negative offset")
+	}
+}
+
+func TestApplyUntil_Time(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	var data bytes.Buffer
+	data.Write(encode("SET", "plain", "value"))
+	data.Write(persistence.Annotate(encode("SET", "a", "1"), start))
+	data.Write(persistence.Annotate(encode("SET", "b", "2"), start.Add(time.Minute)))
+	end := int64(data.Len())
+	data.Write(persistence.Annotate(encode("DEL", "a"), start.Add(2*time.Minute)))
+	// Entries after the target can't come back even without a timestamp
+	data.Write(encode("SET", "late", "value"))
+
+	storage := store.NewStorage()
+	recovered, err := NewReader(&data).ApplyUntil(storage, Target{Time: start.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("ApplyUntil failed: %v", err)
+	}
+	if recovered.Offset != end || recovered.Commands != 3 || !recovered.Last.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Unexpected result %+v", recovered)
+	}
+	if !storage.Exists("plain") || !storage.Exists("a") || !storage.Exists("b") || storage.Exists("late") {
+		t.Fatal("Expected the state as of the target")
+	}
+}
+
+func TestApplyUntil_Offset(t *testing.T) {
+	first := encode("SET", "a", "1")
+	second := encode("SET", "b", "2")
+	data := append(bytes.Clone(first), second...)
+	// Garbage past the target doesn't matter
+	data = append(data, "*2\r\n$x"...)
+
+	for _, offset := range []int64{int64(len(first)), int64(len(first) + len(second) - 1)} {
+		storage := store.NewStorage()
+		recovered, err := NewReader(bytes.NewReader(data)).ApplyUntil(storage, Target{Offset: offset})
+		if err != nil {
+			t.Fatalf("Offset %d: ApplyUntil failed: %v", offset, err)
+		}
+		if recovered.Offset != int64(len(first)) || !storage.Exists("a") || storage.Exists("b") {
+			t.Fatalf("Offset %d: expected only the first command, got %+v", offset, recovered)
+		}
+	}
+
+	storage := store.NewStorage()
+	recovered, err := NewReader(bytes.NewReader(data)).ApplyUntil(storage, Target{Offset: int64(len(first) + len(second))})
+	if err != nil || recovered.Commands != 2 {
+		t.Fatalf("Expected both commands, got %+v, %v", recovered, err)
+	}
+}
+
+func TestApplyUntil_BeforePreamble(t *testing.T) {
+	createdAt := time.UnixMilli(1700000000000)
+	var data bytes.Buffer
+	persistence.WriteSnapshot(&data, persistence.SnapshotHeader{CreatedAt: createdAt}, map[string]store.Value{
+		"key": {Kind: store.StringType, Str: []byte("value")},
+	})
+	data.Write(persistence.Annotate(encode("SET", "a", "1"), createdAt.Add(time.Second)))
+	raw := data.Bytes()
+
+	for _, target := range []Target{{Time: createdAt.Add(-time.Second)}, {Offset: 1}} {
+		reader := NewReader(bytes.NewReader(raw))
+		storage := store.NewStorage()
+		reader.ReadPreamble(storage.Restore)
+		if _, err := reader.ApplyUntil(storage, target); !errors.Is(err, ErrTargetBeforePreamble) {
+			t.Fatalf("Target %+v: expected ErrTargetBeforePreamble, got %v", target, err)
+		}
+	}
+
+	reader := NewReader(bytes.NewReader(raw))
+	storage := store.NewStorage()
+	reader.ReadPreamble(storage.Restore)
+	recovered, err := reader.ApplyUntil(storage, Target{Time: createdAt})
+	if err != nil || recovered.Commands != 0 || !storage.Exists("key") {
+		t.Fatalf("Expected the preamble alone, got %+v, %v", recovered, err)
+	}
+}
@@ -7,16 +7,70 @@ import (
 
 const (
 	SimpleStringPrefix = "+"
-	ErrorPrefix        = "-ERR "
+	ErrorTypePrefix    = "-"
 	BulkStringPrefix   = "$"
 	IntegerPrefix      = ":"
 	ArrayPrefix        = "*"
 )
 
+// Error codes sent as the first word of an error reply, so clients can
+// tell error kinds apart without parsing the message.
+const (
+	CodeErr       = "ERR"
+	CodeWrongType = "WRONGTYPE"
+	CodeNoScript  = "NOSCRIPT"
+	CodeOOM       = "OOM"
+	CodeNoAuth    = "NOAUTH"
+	CodeNoPerm    = "NOPERM"
+	CodeWrongPass = "WRONGPASS"
+	CodeMisconf   = "MISCONF"
+)
+
+// Error is a protocol level error with a Redis-style code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func NewError(code string, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Code + " " + e.Message
+}
+
 func FormatResponse(prefix string, message string) string {
 	return prefix + message + "\r\n"
 }
 
+// FormatError escapes the message, which often echoes what the client
+// sent, so it stays a single line
+func FormatError(err *Error) string {
+	return FormatResponse(ErrorTypePrefix, Sanitize(err.Error()))
+}
+
+// Sanitize escapes control characters as \xNN, so text from a client
+// can't end a reply line early and inject replies of its own
+func Sanitize(s string) string {
+	if !strings.ContainsFunc(s, isControl) {
+		return s
+	}
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if isControl(rune(s[i])) {
+			fmt.Fprintf(&builder, "\\x%02x", s[i])
+		} else {
+			builder.WriteByte(s[i])
+		}
+	}
+	return builder.String()
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
 func FormatBulkString(value []byte) string {
 	if value == nil {
 		return FormatResponse(BulkStringPrefix, "-1")
@@ -34,26 +88,69 @@ func FormatArray(values [][]byte) string {
 	return builder.String()
 }
 
+// FormatNestedArray builds an array from already formatted elements, which
+// may be of any type
+func FormatNestedArray(elements []string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s%d\r\n", ArrayPrefix, len(elements))
+	for _, element := range elements {
+		builder.WriteString(element)
+	}
+	return builder.String()
+}
+
 func ErrWrongTypeResponse() string {
-	return FormatResponse(ErrorPrefix, "Wrong type")
+	return FormatError(NewError(CodeWrongType, "Operation against a key holding the wrong kind of value"))
 }
 
 func ErrInternalResponse() string {
-	return FormatResponse(ErrorPrefix, "Internal error")
+	return FormatError(NewError(CodeErr, "internal error"))
 }
 
 func ErrEmptyCommandResponse() string {
-	return FormatResponse(ErrorPrefix, "Empty command")
+	return FormatError(NewError(CodeErr, "empty command"))
 }
 
 func ErrInvalidIntegerResponse() string {
-	return FormatResponse(ErrorPrefix, "Invalid integer")
+	return FormatError(NewError(CodeErr, "value is not an integer or out of range"))
+}
+
+func ErrSyntaxResponse() string {
+	return FormatError(NewError(CodeErr, "syntax error"))
+}
+
+func ErrWrongArityResponse(name string) string {
+	return FormatError(NewError(CodeErr, fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(name))))
+}
+
+func ErrUnknownCommandResponse(name string) string {
+	return FormatError(NewError(CodeErr, fmt.Sprintf("unknown command '%s'", name)))
+}
+
+func ErrNoAuthResponse() string {
+	return FormatError(NewError(CodeNoAuth, "Authentication required."))
+}
+
+func ErrWrongPassResponse() string {
+	return FormatError(NewError(CodeWrongPass, "invalid username-password pair or user is disabled."))
+}
+
+func ErrNoPermCommandResponse(name string) string {
+	return FormatError(NewError(CodeNoPerm, fmt.Sprintf("this user has no permissions to run the '%s' command", strings.ToLower(name))))
+}
+
+func ErrNoPermKeyResponse() string {
+	return FormatError(NewError(CodeNoPerm, "No permissions to access a key"))
+}
+
+func ErrOOMResponse() string {
+	return FormatError(NewError(CodeOOM, "command not allowed when used memory > 'maxmemory'."))
 }
 
-func ErrWrongArityResponse() string {
-	return FormatResponse(ErrorPrefix, "Wrong number of arguments")
+func ErrNoScriptResponse() string {
+	return FormatError(NewError(CodeNoScript, "No matching script."))
 }
 
-func ErrUnknownCommandResponse() string {
-	return FormatResponse(ErrorPrefix, "Unknown command")
+func ErrAOFWriteResponse(err error) string {
+	return FormatError(NewError(CodeMisconf, fmt.Sprintf("Errors writing to the AOF file: %v", err)))
 }
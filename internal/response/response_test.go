@@ -1,6 +1,7 @@
 package response
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -14,7 +15,7 @@ func TestFormatResponse(t *testing.T) {
 }
 
 func TestFormatResponse_Error(t *testing.T) {
-	result := FormatResponse(ErrorPrefix, "Error message")
+	result := FormatResponse(ErrorTypePrefix, "ERR Error message")
 	expected := "-ERR Error message\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
@@ -162,9 +163,24 @@ func TestFormatArray_WithNil(t *testing.T) {
 	}
 }
 
+func TestFormatNestedArray(t *testing.T) {
+	result := FormatNestedArray([]string{
+		FormatResponse(IntegerPrefix, "1"),
+		FormatArray([][]byte{[]byte("get"), []byte("key")}),
+		FormatBulkString(nil),
+	})
+	expected := "*3\r\n:1\r\n*2\r\n$3\r\nget\r\n$3\r\nkey\r\n$-1\r\n"
+	if result != expected {
+		t.Fatalf("Expected %q, got %q", expected, result)
+	}
+	if result := FormatNestedArray(nil); result != "*0\r\n" {
+		t.Fatalf("Expected empty array, got %q", result)
+	}
+}
+
 func TestErrWrongTypeResponse(t *testing.T) {
 	result := ErrWrongTypeResponse()
-	expected := "-ERR Wrong type\r\n"
+	expected := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
 	}
@@ -172,7 +188,7 @@ func TestErrWrongTypeResponse(t *testing.T) {
 
 func TestErrInternalResponse(t *testing.T) {
 	result := ErrInternalResponse()
-	expected := "-ERR Internal error\r\n"
+	expected := "-ERR internal error\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
 	}
@@ -180,7 +196,7 @@ func TestErrInternalResponse(t *testing.T) {
 
 func TestErrEmptyCommandResponse(t *testing.T) {
 	result := ErrEmptyCommandResponse()
-	expected := "-ERR Empty command\r\n"
+	expected := "-ERR empty command\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
 	}
@@ -188,34 +204,92 @@ func TestErrEmptyCommandResponse(t *testing.T) {
 
 func TestErrInvalidIntegerResponse(t *testing.T) {
 	result := ErrInvalidIntegerResponse()
-	expected := "-ERR Invalid integer\r\n"
+	expected := "-ERR value is not an integer or out of range\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
 	}
 }
 
 func TestErrWrongArityResponse(t *testing.T) {
-	result := ErrWrongArityResponse()
-	expected := "-ERR Wrong number of arguments\r\n"
+	result := ErrWrongArityResponse("GET")
+	expected := "-ERR wrong number of arguments for 'get' command\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
 	}
 }
 
 func TestErrUnknownCommandResponse(t *testing.T) {
-	result := ErrUnknownCommandResponse()
-	expected := "-ERR Unknown command\r\n"
+	result := ErrUnknownCommandResponse("foo")
+	expected := "-ERR unknown command 'foo'\r\n"
+	if result != expected {
+		t.Fatalf("Expected %q, got %q", expected, result)
+	}
+}
+
+func TestErrUnknownCommandResponse_Escaped(t *testing.T) {
+	result := ErrUnknownCommandResponse("foo\r\n+OK")
+	expected := "-ERR unknown command 'foo\\x0d\\x0a+OK'\r\n"
+	if result != expected {
+		t.Fatalf("Expected %q, got %q", expected, result)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := map[string]string{
+		"plain text":      "plain text",
+		"a\x00b\tc\x7f": "a\\x00b\\x09c\\x7f",
+		"héllo":           "héllo",
+	}
+	for input, expected := range tests {
+		if result := Sanitize(input); result != expected {
+			t.Fatalf("Sanitize(%q): expected %q, got %q", input, expected, result)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	result := FormatError(NewError(CodeNoAuth, "Authentication required."))
+	expected := "-NOAUTH Authentication required.\r\n"
 	if result != expected {
 		t.Fatalf("Expected %q, got %q", expected, result)
 	}
 }
 
+func TestError_Error(t *testing.T) {
+	err := NewError(CodeWrongType, "bad")
+	if err.Error() != "WRONGTYPE bad" {
+		t.Fatalf("Expected %q, got %q", "WRONGTYPE bad", err.Error())
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	testCases := []struct {
+		result string
+		prefix string
+	}{
+		{ErrWrongTypeResponse(), "-WRONGTYPE "},
+		{ErrNoAuthResponse(), "-NOAUTH "},
+		{ErrOOMResponse(), "-OOM "},
+		{ErrNoScriptResponse(), "-NOSCRIPT "},
+		{ErrSyntaxResponse(), "-ERR "},
+		{ErrWrongPassResponse(), "-WRONGPASS "},
+		{ErrNoPermCommandResponse("GET"), "-NOPERM "},
+		{ErrNoPermKeyResponse(), "-NOPERM "},
+		{ErrAOFWriteResponse(errors.New("disk full")), "-MISCONF Errors writing to the AOF file: disk full"},
+	}
+	for _, tc := range testCases {
+		if !strings.HasPrefix(tc.result, tc.prefix) {
+			t.Errorf("Expected %q to start with %q", tc.result, tc.prefix)
+		}
+	}
+}
+
 func TestResponseConstants(t *testing.T) {
 	if SimpleStringPrefix != "+" {
 		t.Fatalf("Expected SimpleStringPrefix to be '+', got %q", SimpleStringPrefix)
 	}
-	if ErrorPrefix != "-ERR " {
-		t.Fatalf("Expected ErrorPrefix to be '-ERR ', got %q", ErrorPrefix)
+	if ErrorTypePrefix != "-" {
+		t.Fatalf("Expected ErrorTypePrefix to be '-', got %q", ErrorTypePrefix)
 	}
 	if BulkStringPrefix != "$" {
 		t.Fatalf("Expected BulkStringPrefix to be '$', got %q", BulkStringPrefix)
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/flash10042/kv-chat/internal/acl"
+	"github.com/flash10042/kv-chat/internal/response"
+)
+
+var aclSubcommands = map[string]serverCommand{
+	"SETUSER": {Name: "SETUSER", Arity: -3, Handler: aclSetUserHandler, Categories: []string{"admin", "dangerous"}, Redact: true},
+	"GETUSER": {Name: "GETUSER", Arity: 3, Handler: aclGetUserHandler, Categories: []string{"admin", "dangerous"}},
+	"DELUSER": {Name: "DELUSER", Arity: -3, Handler: aclDelUserHandler, Categories: []string{"admin", "dangerous"}},
+	"LIST":    {Name: "LIST", Arity: 2, Handler: aclListHandler, Categories: []string{"admin", "dangerous"}},
+	"WHOAMI":  {Name: "WHOAMI", Arity: 2, Handler: aclWhoAmIHandler, Categories: []string{"connection"}},
+}
+
+func aclSetUserHandler(c *client, args [][]byte) string {
+	rules := make([]string, len(args)-3)
+	for i, arg := range args[3:] {
+		rules[i] = string(arg)
+	}
+	if err := c.options.ACL.SetUser(string(args[2]), rules); err != nil {
+		return response.FormatError(response.NewError(response.CodeErr, err.Error()))
+	}
+	if err := c.saveACL(); err != nil {
+		return aclSaveErrorResponse(err)
+	}
+	return okResponse()
+}
+
+func aclGetUserHandler(c *client, args [][]byte) string {
+	user, ok := c.options.ACL.GetUser(string(args[2]))
+	if !ok {
+		return response.FormatBulkString(nil)
+	}
+	return formatUser(user)
+}
+
+func aclDelUserHandler(c *client, args [][]byte) string {
+	deleted := 0
+	for _, arg := range args[2:] {
+		ok, err := c.options.ACL.DelUser(string(arg))
+		if err != nil {
+			return response.FormatError(response.NewError(response.CodeErr, err.Error()))
+		}
+		if ok {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		if err := c.saveACL(); err != nil {
+			return aclSaveErrorResponse(err)
+		}
+	}
+	return response.FormatResponse(response.IntegerPrefix, strconv.Itoa(deleted))
+}
+
+func aclListHandler(c *client, args [][]byte) string {
+	lines := c.options.ACL.List()
+	values := make([][]byte, len(lines))
+	for i, line := range lines {
+		values[i] = []byte(line)
+	}
+	return response.FormatArray(values)
+}
+
+func aclWhoAmIHandler(c *client, args [][]byte) string {
+	return response.FormatBulkString([]byte(c.User()))
+}
+
+func (c *client) saveACL() error {
+	if c.options.ACLFile == "" {
+		return nil
+	}
+	if err := c.options.ACL.SaveFile(c.options.ACLFile); err != nil {
+		log.Printf("Failed to save ACL file: %v", err)
+		return err
+	}
+	return nil
+}
+
+func aclSaveErrorResponse(err error) string {
+	return response.FormatError(response.NewError(response.CodeErr,
+		fmt.Sprintf("change applied in memory but failed to save ACL file: %v", err)))
+}
+
+// formatUser returns ACL GETUSER fields as a flat array of name/value pairs
+func formatUser(user acl.User) string {
+	flags := []string{"off"}
+	if user.Enabled {
+		flags[0] = "on"
+	}
+	if user.NoPass {
+		flags = append(flags, "nopass")
+	}
+	commandRules := strings.Join(user.Commands, " ")
+	if commandRules == "" {
+		commandRules = "-@all"
+	}
+	keys := make([]string, len(user.Keys))
+	for i, k := range user.Keys {
+		keys[i] = "~" + k
+	}
+	return response.FormatArray([][]byte{
+		[]byte("flags"), []byte(strings.Join(flags, " ")),
+		[]byte("passwords"), []byte(strings.Join(user.Passwords, " ")),
+		[]byte("commands"), []byte(commandRules),
+		[]byte("keys"), []byte(strings.Join(keys, " ")),
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flash10042/kv-chat/internal/acl"
+)
+
+func TestACL_Commands(t *testing.T) {
+	options := DefaultOptions()
+	options.ACLFile = filepath.Join(t.TempDir(), "users.acl")
+	conn, _ := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "ACL", "WHOAMI"); reply != "$7\r\ndefault\r\n" {
+		t.Fatalf("Expected default, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "SETUSER", "alice", "on", "nopass", "~chat:*", "+@read"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "SETUSER", "alice", "+@bogus"); !strings.HasPrefix(reply, "-ERR ") {
+		t.Fatalf("Expected error for bad rule, got %q", reply)
+	}
+
+	expected := "*2\r\n$35\r\nuser alice on nopass ~chat:* +@read\r\n$31\r\nuser default on nopass ~* +@all\r\n"
+	if reply := sendCommand(t, conn, reader, "ACL", "LIST"); reply != expected {
+		t.Fatalf("Expected %q, got %q", expected, reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "GETUSER", "alice"); !strings.Contains(reply, "+@read") {
+		t.Fatalf("Expected alice's rules, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "GETUSER", "nobody"); reply != "$-1\r\n" {
+		t.Fatalf("Expected null for missing user, got %q", reply)
+	}
+
+	saved := acl.New("")
+	if err := saved.LoadFile(options.ACLFile); err != nil {
+		t.Fatalf("Failed to load saved ACL file: %v", err)
+	}
+	if _, ok := saved.GetUser("alice"); !ok {
+		t.Fatal("alice should be saved to the ACL file")
+	}
+
+	if reply := sendCommand(t, conn, reader, "ACL", "DELUSER", "alice", "nobody"); reply != ":1\r\n" {
+		t.Fatalf("Expected 1, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "DELUSER", "default"); !strings.HasPrefix(reply, "-ERR ") {
+		t.Fatalf("Expected error deleting default user, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "NOPE"); !strings.HasPrefix(reply, "-ERR unknown subcommand") {
+		t.Fatalf("Expected unknown subcommand, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "GETUSER"); !strings.HasPrefix(reply, "-ERR wrong number of arguments for 'acl|getuser'") {
+		t.Fatalf("Expected arity error, got %q", reply)
+	}
+}
+
+// Errors echo the rule or subcommand back, which mustn't add replies of
+// its own
+func TestErrorReplies_EscapeClientInput(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	for _, command := range [][]string{
+		{"CLIENT", "FOO\r\n+OK"},
+		{"ACL", "SETUSER", "bob", "~x\r\n+OK"},
+		{"ACL", "SETUSER", "bob\r\n+OK", "on"},
+	} {
+		reply := sendCommand(t, conn, reader, command...)
+		if !strings.HasPrefix(reply, "-ERR ") || strings.Count(reply, "\r\n") != 1 {
+			t.Fatalf("%q: expected a single error line, got %q", command, reply)
+		}
+		if reply := sendCommand(t, conn, reader, "PING"); reply != "+PONG\r\n" {
+			t.Fatalf("%q: expected the stream to stay in sync, got %q", command, reply)
+		}
+	}
+}
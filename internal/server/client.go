@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// client holds the state of a single connection
+type client struct {
+	ctx     context.Context
+	conn    net.Conn
+	storage *store.Storage
+	aof     *persistence.AOF
+	options Options
+
+	id            int64
+	createdAt     time.Time
+	authenticated bool
+	// Set by CLIENT KILL on itself, the connection closes after the reply
+	closeAfterReply bool
+	// AOF sequence number of the last write, replies wait for it to be
+	// written before they go out
+	aofSeq uint64
+	killed atomic.Bool
+	// Set by MONITOR, the connection streams commands after the reply
+	monitor    *monitor
+	monitoring atomic.Bool
+
+	// Read by other connections through CLIENT LIST and KILL
+	mu              sync.Mutex
+	user            string
+	name            string
+	lastCommand     string
+	lastInteraction time.Time
+	queryBuffer     int
+	outputBuffer    int
+}
+
+func (c *client) User() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.user
+}
+
+func (c *client) setUser(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.user = user
+}
+
+func (c *client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.name
+}
+
+func (c *client) setName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.name = name
+}
+
+func (c *client) recordCommand(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastCommand = command
+	c.lastInteraction = time.Now()
+}
+
+func (c *client) recordBuffers(queryBuffer, outputBuffer int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queryBuffer = queryBuffer
+	c.outputBuffer = outputBuffer
+}
+
+// flush sends the pending replies once the writes they acknowledge are in
+// the AOF. If the AOF failed the replies are dropped, so the client never
+// sees a write acknowledged that wasn't persisted.
+func (c *client) flush(writer *bufio.Writer) error {
+	if c.aof != nil && c.aofSeq != 0 {
+		if err := c.aof.Wait(c.aofSeq); err != nil {
+			return fmt.Errorf("AOF write failed: %w", err)
+		}
+		c.aofSeq = 0
+	}
+	return writer.Flush()
+}
+
+// kill closes the connection, unblocking its pending read
+func (c *client) kill() {
+	c.killed.Store(true)
+	c.conn.Close()
+}
+
+// Info describes the client in CLIENT LIST format
+func (c *client) Info() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	lastCommand := c.lastCommand
+	if lastCommand == "" {
+		lastCommand = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 qbuf=%d obuf=%d user=%s cmd=%s",
+		c.id, c.addr(), addrString(c.conn.LocalAddr()), c.name,
+		int64(now.Sub(c.createdAt).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		c.flags(), c.queryBuffer, c.outputBuffer, c.user, lastCommand)
+}
+
+func (c *client) addr() string {
+	return addrString(c.conn.RemoteAddr())
+}
+
+func (c *client) flags() string {
+	var flags strings.Builder
+	if c.monitoring.Load() {
+		flags.WriteString("O")
+	}
+	if addr := c.conn.LocalAddr(); addr != nil && addr.Network() == "unix" {
+		flags.WriteString("U")
+	}
+	if flags.Len() == 0 {
+		flags.WriteString("N")
+	}
+	return flags.String()
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
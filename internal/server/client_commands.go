@@ -0,0 +1,183 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/response"
+)
+
+var clientSubcommands = map[string]serverCommand{
+	"ID":      {Name: "ID", Arity: 2, Handler: clientIDHandler, Categories: []string{"connection"}},
+	"INFO":    {Name: "INFO", Arity: 2, Handler: clientInfoHandler, Categories: []string{"connection"}},
+	"SETNAME": {Name: "SETNAME", Arity: 3, Handler: clientSetNameHandler, Categories: []string{"connection"}},
+	"GETNAME": {Name: "GETNAME", Arity: 2, Handler: clientGetNameHandler, Categories: []string{"connection"}},
+	"LIST":    {Name: "LIST", Arity: -2, Handler: clientListHandler, Categories: []string{"admin", "dangerous"}},
+	"KILL":    {Name: "KILL", Arity: -3, Handler: clientKillHandler, Categories: []string{"admin", "dangerous"}},
+	"PAUSE":   {Name: "PAUSE", Arity: -3, Handler: clientPauseHandler, Categories: []string{"admin", "dangerous"}},
+	"UNPAUSE": {Name: "UNPAUSE", Arity: 2, Handler: clientUnpauseHandler, Categories: []string{"admin", "dangerous"}},
+}
+
+func clientIDHandler(c *client, args [][]byte) string {
+	return response.FormatResponse(response.IntegerPrefix, strconv.FormatInt(c.id, 10))
+}
+
+func clientInfoHandler(c *client, args [][]byte) string {
+	return response.FormatBulkString([]byte(c.Info() + "\n"))
+}
+
+func clientSetNameHandler(c *client, args [][]byte) string {
+	name := string(args[2])
+	for i := 0; i < len(name); i++ {
+		// Names end up in the space separated CLIENT LIST output
+		if name[i] < '!' || name[i] > '~' {
+			return response.FormatError(response.NewError(response.CodeErr,
+				"Client names cannot contain spaces, newlines or special characters."))
+		}
+	}
+	c.setName(name)
+	return okResponse()
+}
+
+func clientGetNameHandler(c *client, args [][]byte) string {
+	name := c.Name()
+	if name == "" {
+		return response.FormatBulkString(nil)
+	}
+	return response.FormatBulkString([]byte(name))
+}
+
+// CLIENT LIST [ID id [id ...]]
+func clientListHandler(c *client, args [][]byte) string {
+	clients := c.options.Clients.snapshot()
+
+	if len(args) > 2 {
+		if strings.ToUpper(string(args[2])) != "ID" || len(args) == 3 {
+			return response.ErrSyntaxResponse()
+		}
+		var filtered []*client
+		for _, arg := range args[3:] {
+			id, err := strconv.ParseInt(string(arg), 10, 64)
+			if err != nil {
+				return response.ErrInvalidIntegerResponse()
+			}
+			if other, ok := c.options.Clients.get(id); ok {
+				filtered = append(filtered, other)
+			}
+		}
+		clients = filtered
+	}
+
+	var builder strings.Builder
+	for _, other := range clients {
+		builder.WriteString(other.Info())
+		builder.WriteString("\n")
+	}
+	return response.FormatBulkString([]byte(builder.String()))
+}
+
+// CLIENT KILL addr, or CLIENT KILL [ID id] [ADDR addr] [USER user] [SKIPME yes|no]
+func clientKillHandler(c *client, args [][]byte) string {
+	if len(args) == 3 {
+		addr := string(args[2])
+		for _, other := range c.options.Clients.snapshot() {
+			if other.addr() == addr {
+				c.killClient(other)
+				return okResponse()
+			}
+		}
+		return response.FormatError(response.NewError(response.CodeErr, "No such client"))
+	}
+
+	if len(args)%2 != 0 {
+		return response.ErrSyntaxResponse()
+	}
+	var (
+		id     int64
+		addr   string
+		user   string
+		skipMe = true
+	)
+	for i := 2; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed <= 0 {
+				return response.FormatError(response.NewError(response.CodeErr, "client-id should be greater than 0"))
+			}
+			id = parsed
+		case "ADDR":
+			addr = value
+		case "USER":
+			user = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return response.ErrSyntaxResponse()
+			}
+		default:
+			return response.ErrSyntaxResponse()
+		}
+	}
+
+	killed := 0
+	for _, other := range c.options.Clients.snapshot() {
+		if id != 0 && other.id != id {
+			continue
+		}
+		if addr != "" && other.addr() != addr {
+			continue
+		}
+		if user != "" && other.User() != user {
+			continue
+		}
+		if skipMe && other == c {
+			continue
+		}
+		c.killClient(other)
+		killed++
+	}
+	return response.FormatResponse(response.IntegerPrefix, strconv.Itoa(killed))
+}
+
+// killClient closes another client's connection right away, or this
+// client's once the reply is sent
+func (c *client) killClient(other *client) {
+	if other == c {
+		c.closeAfterReply = true
+		return
+	}
+	other.kill()
+}
+
+// CLIENT PAUSE timeout [WRITE|ALL]
+func clientPauseHandler(c *client, args [][]byte) string {
+	milliseconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || milliseconds < 0 {
+		return response.FormatError(response.NewError(response.CodeErr, "timeout is not an integer or out of range"))
+	}
+
+	writesOnly := false
+	switch {
+	case len(args) == 3:
+	case len(args) == 4 && strings.ToUpper(string(args[3])) == "WRITE":
+		writesOnly = true
+	case len(args) == 4 && strings.ToUpper(string(args[3])) == "ALL":
+	default:
+		return response.ErrSyntaxResponse()
+	}
+
+	c.options.Clients.Pause(time.Duration(milliseconds)*time.Millisecond, writesOnly)
+	return okResponse()
+}
+
+func clientUnpauseHandler(c *client, args [][]byte) string {
+	c.options.Clients.Unpause()
+	return okResponse()
+}
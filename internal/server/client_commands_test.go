@@ -0,0 +1,205 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_IDAndName(t *testing.T) {
+	address, _ := startTestServer(t, DefaultOptions())
+	conn, reader := dialTestServer(t, address)
+
+	if reply := sendCommand(t, conn, reader, "CLIENT", "ID"); reply != ":1\r\n" {
+		t.Fatalf("Expected id 1, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "GETNAME"); reply != "$-1\r\n" {
+		t.Fatalf("Expected null name, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "SETNAME", "bad name"); !strings.HasPrefix(reply, "-ERR Client names cannot contain spaces") {
+		t.Fatalf("Expected invalid name error, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "SETNAME", "chat-api"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "CLIENT", "GETNAME"); reply != "$8\r\nchat-api\r\n" {
+		t.Fatalf("Expected chat-api, got %q", reply)
+	}
+
+	reply := sendCommand(t, conn, reader, "CLIENT", "INFO")
+	for _, field := range []string{"id=1 ", "addr=" + conn.LocalAddr().String() + " ", "name=chat-api ", "user=default ", "cmd=client|info", "flags=N ", "db=0 "} {
+		if !strings.Contains(reply, field) {
+			t.Errorf("Expected %q in %q", field, reply)
+		}
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	address, _ := startTestServer(t, DefaultOptions())
+	first, firstReader := dialTestServer(t, address)
+	second, secondReader := dialTestServer(t, address)
+
+	sendCommand(t, first, firstReader, "CLIENT", "SETNAME", "first")
+	sendCommand(t, second, secondReader, "GET", "key")
+
+	reply := sendCommand(t, first, firstReader, "CLIENT", "LIST")
+	lines := strings.Split(strings.TrimSpace(reply[strings.Index(reply, "\r\n")+2:]), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 clients, got %q", reply)
+	}
+	if !strings.Contains(lines[0], "name=first") || !strings.Contains(lines[0], "cmd=client|list") {
+		t.Fatalf("Unexpected first client: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "cmd=get") {
+		t.Fatalf("Unexpected second client: %q", lines[1])
+	}
+
+	reply = sendCommand(t, first, firstReader, "CLIENT", "LIST", "ID", "2")
+	if strings.Count(reply, "id=") != 1 || !strings.Contains(reply, "id=2 ") {
+		t.Fatalf("Expected only client 2, got %q", reply)
+	}
+}
+
+func TestClient_List_UnknownCommandName(t *testing.T) {
+	address, _ := startTestServer(t, DefaultOptions())
+	first, firstReader := dialTestServer(t, address)
+	second, secondReader := dialTestServer(t, address)
+
+	for _, test := range []struct {
+		command []string
+		cmd     string
+	}{
+		{[]string{"x y\nid=999 addr=evil name=admin"}, "cmd=unknown"},
+		{[]string{"CLIENT", "x\nid=999"}, "cmd=client"},
+	} {
+		command := test.command
+		sendCommand(t, second, secondReader, command...)
+		reply := sendCommand(t, first, firstReader, "CLIENT", "LIST")
+		lines := strings.Split(strings.TrimSpace(reply[strings.Index(reply, "\r\n")+2:]), "\n")
+		if len(lines) != 2 || strings.Contains(reply, "id=999") {
+			t.Fatalf("%q: expected 2 clients, got %q", command, reply)
+		}
+		if !strings.HasSuffix(lines[1], test.cmd) {
+			t.Fatalf("%q: unexpected second client: %q", command, lines[1])
+		}
+	}
+}
+
+func TestClient_Kill(t *testing.T) {
+	options := DefaultOptions()
+	options.ACL.SetUser("worker", []string{"on", "nopass", "allkeys", "allcommands"})
+	address, _ := startTestServer(t, options)
+	admin, adminReader := dialTestServer(t, address)
+	byAddr, byAddrReader := dialTestServer(t, address)
+	byID, byIDReader := dialTestServer(t, address)
+	byUser, byUserReader := dialTestServer(t, address)
+
+	sendCommand(t, byAddr, byAddrReader, "PING")
+	id := strings.TrimSpace(strings.TrimPrefix(sendCommand(t, byID, byIDReader, "CLIENT", "ID"), ":"))
+	sendCommand(t, byUser, byUserReader, "AUTH", "worker", "x")
+
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "KILL", byAddr.LocalAddr().String()); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "KILL", "ID", id); reply != ":1\r\n" {
+		t.Fatalf("Expected 1, got %q", reply)
+	}
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "KILL", "USER", "worker"); reply != ":1\r\n" {
+		t.Fatalf("Expected 1, got %q", reply)
+	}
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "KILL", "127.0.0.1:1"); reply != "-ERR No such client\r\n" {
+		t.Fatalf("Expected no such client, got %q", reply)
+	}
+
+	for _, reader := range []interface{ ReadByte() (byte, error) }{byAddrReader, byIDReader, byUserReader} {
+		if _, err := reader.ReadByte(); err != io.EOF {
+			t.Fatalf("Expected killed connection to be closed, got %v", err)
+		}
+	}
+
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "KILL", "USER", "default"); reply != ":0\r\n" {
+		t.Fatalf("Expected SKIPME to spare the caller, got %q", reply)
+	}
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "KILL", "USER", "default", "SKIPME", "no"); reply != ":1\r\n" {
+		t.Fatalf("Expected 1, got %q", reply)
+	}
+	if _, err := adminReader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected own connection to close after the reply, got %v", err)
+	}
+}
+
+func TestClient_Pause(t *testing.T) {
+	address, storage := startTestServer(t, DefaultOptions())
+	admin, adminReader := dialTestServer(t, address)
+	conn, reader := dialTestServer(t, address)
+	storage.Set("key", []byte("value"))
+
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "PAUSE", "10000", "WRITE"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "key"); reply != "$5\r\nvalue\r\n" {
+		t.Fatalf("Reads should not be paused, got %q", reply)
+	}
+
+	replies := make(chan string, 1)
+	go func() {
+		replies <- sendCommand(t, conn, reader, "SET", "key", "other")
+	}()
+	select {
+	case reply := <-replies:
+		t.Fatalf("Write should be paused, got %q", reply)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if reply := sendCommand(t, admin, adminReader, "CLIENT", "UNPAUSE"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	select {
+	case reply := <-replies:
+		if reply != "+OK\r\n" {
+			t.Fatalf("Expected OK, got %q", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write should run after unpause")
+	}
+}
+
+func TestClient_PauseExpires(t *testing.T) {
+	address, _ := startTestServer(t, DefaultOptions())
+	conn, reader := dialTestServer(t, address)
+
+	sendCommand(t, conn, reader, "CLIENT", "PAUSE", "100")
+	start := time.Now()
+	if reply := sendCommand(t, conn, reader, "GET", "key"); reply != "$-1\r\n" {
+		t.Fatalf("Expected null, got %q", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("GET should wait for the pause, took %v", elapsed)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	options := DefaultOptions()
+	options.ACL.SetUser("app", []string{"on", "nopass", "allkeys", "+@connection", "+@read"})
+	address, _ := startTestServer(t, options)
+	conn, reader := dialTestServer(t, address)
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"CLIENT", "NOPE"}, "-ERR unknown subcommand 'NOPE' for 'client'"},
+		{[]string{"CLIENT", "SETNAME"}, "-ERR wrong number of arguments for 'client|setname' command"},
+		{[]string{"CLIENT", "PAUSE", "abc"}, "-ERR timeout is not an integer or out of range"},
+		{[]string{"CLIENT", "KILL", "ID", "1", "SKIPME"}, "-ERR syntax error"},
+		{[]string{"AUTH", "app", "x"}, "+OK"},
+		{[]string{"CLIENT", "SETNAME", "app"}, "+OK"},
+		{[]string{"CLIENT", "LIST"}, "-NOPERM this user has no permissions to run the 'client' command"},
+	}
+	for _, tc := range testCases {
+		if reply := sendCommand(t, conn, reader, tc.args...); reply != tc.expected+"\r\n" {
+			t.Errorf("%v: expected %q, got %q", tc.args, tc.expected, reply)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientList tracks the connected clients, shared by all connections
+type ClientList struct {
+	mu      sync.Mutex
+	clients map[int64]*client
+	nextID  atomic.Int64
+
+	pauseMu     sync.Mutex
+	pausedUntil time.Time
+	pauseWrites bool // only writes are paused
+	// Closed and replaced when the pause is lifted or changed
+	pauseChanged chan struct{}
+}
+
+func NewClientList() *ClientList {
+	return &ClientList{
+		clients:      make(map[int64]*client),
+		pauseChanged: make(chan struct{}),
+	}
+}
+
+// add registers the client and assigns its id, unless maxClients are
+// already connected. maxClients <= 0 means no limit.
+func (l *ClientList) add(c *client, maxClients int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if maxClients > 0 && len(l.clients) >= maxClients {
+		return false
+	}
+	c.id = l.nextID.Add(1)
+	l.clients[c.id] = c
+	return true
+}
+
+func (l *ClientList) remove(c *client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.clients, c.id)
+}
+
+func (l *ClientList) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.clients)
+}
+
+// snapshot returns the connected clients ordered by id
+func (l *ClientList) snapshot() []*client {
+	l.mu.Lock()
+	clients := make([]*client, 0, len(l.clients))
+	for _, c := range l.clients {
+		clients = append(clients, c)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].id < clients[j].id
+	})
+	return clients
+}
+
+func (l *ClientList) get(id int64) (*client, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[id]
+	return c, ok
+}
+
+// Pause holds back commands from all clients until the timeout passes or
+// Unpause is called. With writesOnly, read commands still run.
+func (l *ClientList) Pause(timeout time.Duration, writesOnly bool) {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+
+	l.pausedUntil = time.Now().Add(timeout)
+	l.pauseWrites = writesOnly
+	close(l.pauseChanged)
+	l.pauseChanged = make(chan struct{})
+}
+
+func (l *ClientList) Unpause() {
+	l.Pause(0, false)
+}
+
+// waitIfPaused blocks while a pause applies to the command, or until ctx
+// is done
+func (l *ClientList) waitIfPaused(ctx context.Context, isWrite bool) {
+	for {
+		l.pauseMu.Lock()
+		remaining := time.Until(l.pausedUntil)
+		applies := remaining > 0 && (isWrite || !l.pauseWrites)
+		changed := l.pauseChanged
+		l.pauseMu.Unlock()
+
+		if !applies {
+			return
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/flash10042/kv-chat/internal/acl"
+	"github.com/flash10042/kv-chat/internal/commands"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/response"
+	"github.com/flash10042/kv-chat/internal/slowlog"
+)
+
+// Commands that need the connection or server state rather than just the
+// storage. They are never written to the AOF.
+type serverCommand struct {
+	Name       string
+	Arity      int
+	Handler    func(c *client, args [][]byte) string
+	Categories []string
+	// Allowed before the client has authenticated
+	NoAuth bool
+	// Arguments carry secrets and are hidden from MONITOR
+	Redact bool
+	// Commands like ACL LIST take a subcommand as the first argument. Each
+	// subcommand has its own arity, categories and handler.
+	Subcommands map[string]serverCommand
+}
+
+var serverCommands = map[string]serverCommand{}
+
+func registerServerCommand(command serverCommand) {
+	if _, ok := serverCommands[command.Name]; ok {
+		panic(fmt.Sprintf("Command %s already registered", command.Name))
+	}
+	if _, ok := commands.Registry[command.Name]; ok {
+		panic(fmt.Sprintf("Command %s already registered", command.Name))
+	}
+	serverCommands[command.Name] = command
+}
+
+func init() {
+	registerServerCommand(serverCommand{
+		Name:       "AUTH",
+		Arity:      -2,
+		Handler:    authHandler,
+		Categories: []string{"connection"},
+		NoAuth:     true,
+		Redact:     true,
+	})
+	registerServerCommand(serverCommand{
+		Name:        "ACL",
+		Arity:       -2,
+		Subcommands: aclSubcommands,
+	})
+	registerServerCommand(serverCommand{
+		Name:        "CLIENT",
+		Arity:       -2,
+		Subcommands: clientSubcommands,
+	})
+	registerServerCommand(serverCommand{
+		Name:       "INFO",
+		Arity:      -1,
+		Handler:    infoHandler,
+		Categories: []string{"dangerous"},
+	})
+	registerServerCommand(serverCommand{
+		Name:       "MONITOR",
+		Arity:      1,
+		Handler:    monitorHandler,
+		Categories: []string{"admin", "dangerous"},
+	})
+	registerServerCommand(serverCommand{
+		Name:       "SAVE",
+		Arity:      1,
+		Handler:    saveHandler,
+		Categories: []string{"admin", "dangerous"},
+	})
+	registerServerCommand(serverCommand{
+		Name:       "BGSAVE",
+		Arity:      1,
+		Handler:    bgsaveHandler,
+		Categories: []string{"admin", "dangerous"},
+	})
+	registerServerCommand(serverCommand{
+		Name:       "BGREWRITEAOF",
+		Arity:      1,
+		Handler:    bgrewriteaofHandler,
+		Categories: []string{"admin", "dangerous"},
+	})
+	registerServerCommand(serverCommand{
+		Name:        "SLOWLOG",
+		Arity:       -2,
+		Subcommands: slowlogSubcommands,
+	})
+}
+
+// execute runs a command on behalf of the client, enforcing authentication
+// and ACL rules before handing data commands to the dispatcher
+func (c *client) execute(args [][]byte) string {
+	if len(args) == 0 {
+		return response.ErrEmptyCommandResponse()
+	}
+	name := strings.ToUpper(string(args[0]))
+	c.options.Stats.commandsProcessed.Add(1)
+
+	if command, ok := serverCommands[name]; ok {
+		fullName, recorded := name, name
+		if command.Subcommands != nil && len(args) > 1 {
+			fullName = name + "|" + strings.ToUpper(string(args[1]))
+			// CLIENT LIST only shows names that exist, never raw input
+			if _, ok := command.Subcommands[strings.ToUpper(string(args[1]))]; ok {
+				recorded = fullName
+			}
+		}
+		c.recordCommand(strings.ToLower(recorded))
+		if !c.authenticated && !command.NoAuth {
+			return response.ErrNoAuthResponse()
+		}
+		if !protocol.CheckArity(len(args), command.Arity) {
+			return response.ErrWrongArityResponse(name)
+		}
+		// Leading arguments MONITOR shows even for redacted commands
+		shown := 1
+		if command.Subcommands != nil {
+			shown = 2
+			subcommand, ok := command.Subcommands[strings.ToUpper(string(args[1]))]
+			if !ok {
+				return response.FormatError(response.NewError(response.CodeErr,
+					fmt.Sprintf("unknown subcommand '%s' for '%s'", args[1], strings.ToLower(name))))
+			}
+			if !protocol.CheckArity(len(args), subcommand.Arity) {
+				return response.ErrWrongArityResponse(fullName)
+			}
+			command = subcommand
+		}
+		if !command.NoAuth {
+			if reply, ok := c.checkACL(name, command.Categories, nil); !ok {
+				return reply
+			}
+		}
+		if command.Redact {
+			c.options.Monitors.feed(c, redactArgs(args, shown))
+		} else {
+			c.options.Monitors.feed(c, args)
+		}
+		return command.Handler(c, args)
+	}
+
+	if command, ok := commands.Registry[name]; ok && !command.IsPrivate {
+		c.recordCommand(strings.ToLower(name))
+	} else {
+		c.recordCommand("unknown")
+	}
+	if !c.authenticated {
+		return response.ErrNoAuthResponse()
+	}
+	// Unknown commands and bad arity are reported by the dispatcher
+	if command, ok := commands.Registry[name]; ok && !command.IsPrivate && protocol.CheckArity(len(args), command.Arity) {
+		if reply, ok := c.checkACL(name, command.Categories, command.Keys(args)); !ok {
+			return reply
+		}
+		c.options.Clients.waitIfPaused(c.ctx, command.Mutates)
+		c.options.Monitors.feed(c, args)
+	}
+	reply, seq := protocol.DispatchCommandWithOptions(protocol.DispatchModePublic, args, c.storage, c.aof, protocol.DispatchOptions{
+		SlowLog:  c.options.SlowLog,
+		Client:   slowlog.Client{Addr: c.addr(), Name: c.Name()},
+		AsyncAOF: true,
+	})
+	if seq != 0 {
+		c.aofSeq = seq
+	}
+	return reply
+}
+
+func (c *client) checkACL(name string, categories []string, keys [][]byte) (string, bool) {
+	switch c.options.ACL.Check(c.User(), name, categories, keys) {
+	case nil:
+		return "", true
+	case acl.ErrNoPermKey:
+		return response.ErrNoPermKeyResponse(), false
+	default:
+		return response.ErrNoPermCommandResponse(name), false
+	}
+}
+
+func redactArgs(args [][]byte, shown int) [][]byte {
+	redacted := make([][]byte, len(args))
+	for i, arg := range args {
+		if i < shown {
+			redacted[i] = arg
+		} else {
+			redacted[i] = []byte("(redacted)")
+		}
+	}
+	return redacted
+}
+
+func okResponse() string {
+	return response.FormatResponse(response.SimpleStringPrefix, "OK")
+}
+
+func authHandler(c *client, args [][]byte) string {
+	var user, password string
+	switch len(args) {
+	case 2:
+		if c.options.ACL.DefaultUserNoPass() {
+			return response.FormatError(response.NewError(response.CodeErr,
+				"AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"))
+		}
+		user, password = acl.DefaultUser, string(args[1])
+	case 3:
+		user, password = string(args[1]), string(args[2])
+	default:
+		return response.ErrSyntaxResponse()
+	}
+
+	if !c.options.ACL.Authenticate(user, password) {
+		log.Printf("Failed AUTH for user %q from %s", user, c.conn.RemoteAddr())
+		return response.ErrWrongPassResponse()
+	}
+	c.setUser(user)
+	c.authenticated = true
+	return okResponse()
+}
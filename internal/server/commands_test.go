@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/flash10042/kv-chat/internal/acl"
+)
+
+func TestAuth_NotRequired(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "SET", "key", "value"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	reply := sendCommand(t, conn, reader, "AUTH", "secret")
+	if !strings.HasPrefix(reply, "-ERR AUTH <password> called without any password configured") {
+		t.Fatalf("Expected AUTH error, got %q", reply)
+	}
+}
+
+func TestAuth_RequirePass(t *testing.T) {
+	options := DefaultOptions()
+	options.ACL = acl.New("secret")
+	conn, _ := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "GET", "key"); !strings.HasPrefix(reply, "-NOAUTH ") {
+		t.Fatalf("Expected NOAUTH, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "WHOAMI"); !strings.HasPrefix(reply, "-NOAUTH ") {
+		t.Fatalf("Expected NOAUTH, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "AUTH", "wrong"); !strings.HasPrefix(reply, "-WRONGPASS ") {
+		t.Fatalf("Expected WRONGPASS, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "AUTH", "secret"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "key"); reply != "$-1\r\n" {
+		t.Fatalf("Expected null bulk string, got %q", reply)
+	}
+}
+
+func TestAuth_UsernamePassword(t *testing.T) {
+	options := DefaultOptions()
+	options.ACL = acl.New("secret")
+	options.ACL.SetUser("reader", []string{"on", ">readpass", "~chat:*", "+@read"})
+	conn, storage := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+	storage.Set("chat:1", []byte("hello"))
+	storage.Set("user:1", []byte("alice"))
+
+	if reply := sendCommand(t, conn, reader, "AUTH", "reader", "readpass"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "ACL", "WHOAMI"); !strings.HasPrefix(reply, "-NOPERM ") {
+		t.Fatalf("Expected NOPERM for ACL, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "chat:1"); reply != "$5\r\nhello\r\n" {
+		t.Fatalf("Expected hello, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "user:1"); reply != "-NOPERM No permissions to access a key\r\n" {
+		t.Fatalf("Expected key NOPERM, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "SET", "chat:1", "x"); reply != "-NOPERM this user has no permissions to run the 'set' command\r\n" {
+		t.Fatalf("Expected command NOPERM, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "NOSUCHCOMMAND"); !strings.HasPrefix(reply, "-ERR unknown command") {
+		t.Fatalf("Expected unknown command, got %q", reply)
+	}
+}
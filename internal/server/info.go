@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/flash10042/kv-chat/internal/response"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+type infoSection struct {
+	Name  string
+	Write func(c *infoContext, builder *strings.Builder)
+}
+
+// infoContext is what the sections of one INFO reply are built from
+type infoContext struct {
+	*client
+	// Read once per reply, as it holds the storage lock
+	keyspace store.Stats
+}
+
+// Sections in the order INFO prints them
+var infoSections = []infoSection{
+	{"server", writeServerInfo},
+	{"clients", writeClientsInfo},
+	{"memory", writeMemoryInfo},
+	{"persistence", writePersistenceInfo},
+	{"stats", writeStatsInfo},
+	{"keyspace", writeKeyspaceInfo},
+}
+
+// INFO [section [section ...]]
+func infoHandler(c *client, args [][]byte) string {
+	selected := map[string]bool{}
+	for _, arg := range args[1:] {
+		selected[strings.ToLower(string(arg))] = true
+	}
+	all := len(selected) == 0 || selected["all"] || selected["everything"] || selected["default"]
+
+	info := &infoContext{client: c, keyspace: c.storage.Stats()}
+	var builder strings.Builder
+	for _, section := range infoSections {
+		if !all && !selected[section.Name] {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + strings.ToUpper(section.Name[:1]) + section.Name[1:] + "\r\n")
+		section.Write(info, &builder)
+	}
+	return response.FormatBulkString([]byte(builder.String()))
+}
+
+func writeInfoField(builder *strings.Builder, key string, value any) {
+	fmt.Fprintf(builder, "%s:%v\r\n", key, value)
+}
+
+func writeServerInfo(c *infoContext, builder *strings.Builder) {
+	uptime := int64(c.options.Stats.Uptime().Seconds())
+	writeInfoField(builder, "kvchat_version", c.options.Version)
+	writeInfoField(builder, "go_version", runtime.Version())
+	writeInfoField(builder, "os", runtime.GOOS+" "+runtime.GOARCH)
+	writeInfoField(builder, "process_id", os.Getpid())
+	writeInfoField(builder, "uptime_in_seconds", uptime)
+	writeInfoField(builder, "uptime_in_days", uptime/(24*60*60))
+}
+
+func writeClientsInfo(c *infoContext, builder *strings.Builder) {
+	writeInfoField(builder, "connected_clients", c.options.Clients.Count())
+	writeInfoField(builder, "maxclients", c.options.MaxClients)
+}
+
+func writeMemoryInfo(c *infoContext, builder *strings.Builder) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	writeInfoField(builder, "used_memory", memStats.HeapAlloc)
+	writeInfoField(builder, "used_memory_human", bytesHuman(memStats.HeapAlloc))
+	writeInfoField(builder, "used_memory_sys", memStats.Sys)
+	writeInfoField(builder, "used_memory_sys_human", bytesHuman(memStats.Sys))
+	writeInfoField(builder, "heap_objects", memStats.HeapObjects)
+	writeInfoField(builder, "gc_cycles", memStats.NumGC)
+}
+
+func writePersistenceInfo(c *infoContext, builder *strings.Builder) {
+	if snapshots := c.options.Snapshots; snapshots != nil {
+		status := snapshots.Status()
+		writeInfoField(builder, "rdb_changes_since_last_save", c.storage.Changes()-status.ChangesAtSave)
+		writeInfoField(builder, "rdb_bgsave_in_progress", boolInfo(status.InProgress))
+		writeInfoField(builder, "rdb_last_save_time", status.LastSave.Unix())
+		writeInfoField(builder, "rdb_last_bgsave_status", statusInfo(status.LastErr))
+	}
+	if c.aof == nil {
+		writeInfoField(builder, "aof_enabled", 0)
+		return
+	}
+	writeInfoField(builder, "aof_enabled", 1)
+	rewrite := c.aof.RewriteStatus()
+	writeInfoField(builder, "aof_rewrite_in_progress", boolInfo(rewrite.InProgress))
+	writeInfoField(builder, "aof_last_bgrewrite_status", statusInfo(rewrite.LastErr))
+	if size, err := c.aof.Size(); err == nil {
+		writeInfoField(builder, "aof_current_size", size)
+	}
+	writeInfoField(builder, "aof_base_size", rewrite.BaseSize)
+}
+
+func statusInfo(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
+
+func boolInfo(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func writeStatsInfo(c *infoContext, builder *strings.Builder) {
+	keyspace := c.keyspace
+	writeInfoField(builder, "total_connections_received", c.options.Stats.ConnectionsReceived())
+	writeInfoField(builder, "total_commands_processed", c.options.Stats.CommandsProcessed())
+	writeInfoField(builder, "rejected_connections", c.options.Stats.RejectedConnections())
+	writeInfoField(builder, "expired_keys", keyspace.ExpiredKeys)
+	writeInfoField(builder, "evicted_keys", keyspace.EvictedKeys)
+	writeInfoField(builder, "keyspace_hits", keyspace.KeyspaceHits)
+	writeInfoField(builder, "keyspace_misses", keyspace.KeyspaceMisses)
+}
+
+func writeKeyspaceInfo(c *infoContext, builder *strings.Builder) {
+	// A single database, listed only when it has keys
+	keyspace := c.keyspace
+	if keyspace.Keys > 0 {
+		writeInfoField(builder, "db0", fmt.Sprintf("keys=%d,expires=%d", keyspace.Keys, keyspace.Expires))
+	}
+}
+
+// bytesHuman formats a byte count like 1.50M
+func bytesHuman(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n) / unit
+	for _, suffix := range []string{"K", "M", "G", "T"} {
+		if value < unit || suffix == "T" {
+			return fmt.Sprintf("%.2f%s", value, suffix)
+		}
+		value /= unit
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	options := DefaultOptions()
+	options.Version = "1.2.3"
+	conn, storage := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+
+	storage.Set("key", []byte("value"))
+	storage.SetEx("session", 100, []byte("value"))
+	sendCommand(t, conn, reader, "GET", "key")
+	sendCommand(t, conn, reader, "GET", "missing")
+
+	reply := sendCommand(t, conn, reader, "INFO")
+	for _, expected := range []string{
+		"# Server\r\n", "kvchat_version:1.2.3\r\n", "uptime_in_seconds:",
+		"\r\n\r\n# Clients\r\n", "connected_clients:1\r\n", "maxclients:10000\r\n",
+		"# Memory\r\n", "used_memory:",
+		"# Persistence\r\n", "aof_enabled:0\r\n",
+		"# Stats\r\n", "total_connections_received:1\r\n", "total_commands_processed:3\r\n",
+		"keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "expired_keys:0\r\n", "evicted_keys:0\r\n",
+		"# Keyspace\r\n", "db0:keys=2,expires=1\r\n",
+	} {
+		if !strings.Contains(reply, expected) {
+			t.Errorf("Expected %q in INFO output:\n%s", expected, reply)
+		}
+	}
+}
+
+func TestInfo_Sections(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	reply := sendCommand(t, conn, reader, "INFO", "clients", "KEYSPACE")
+	if !strings.Contains(reply, "# Clients\r\n") || !strings.Contains(reply, "# Keyspace\r\n") {
+		t.Fatalf("Expected clients and keyspace sections, got %q", reply)
+	}
+	if strings.Contains(reply, "# Server") || strings.Contains(reply, "# Stats") {
+		t.Fatalf("Expected only the selected sections, got %q", reply)
+	}
+	if strings.Contains(reply, "db0:") {
+		t.Fatalf("Empty database should not be listed, got %q", reply)
+	}
+
+	if reply := sendCommand(t, conn, reader, "INFO", "nope"); reply != "$0\r\n\r\n" {
+		t.Fatalf("Expected empty output for unknown section, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "INFO", "all"); strings.Count(reply, "# ") != len(infoSections) {
+		t.Fatalf("Expected every section, got %q", reply)
+	}
+}
+
+func TestInfo_ExpiredKeys(t *testing.T) {
+	conn, storage := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	storage.ExpireAt("missing", time.Now())
+	storage.SetExAt("key", time.Now().Add(10*time.Millisecond), []byte("value"))
+	time.Sleep(20 * time.Millisecond)
+	sendCommand(t, conn, reader, "GET", "key")
+
+	reply := sendCommand(t, conn, reader, "INFO", "stats")
+	if !strings.Contains(reply, "expired_keys:1\r\n") {
+		t.Fatalf("Expected an expired key, got %q", reply)
+	}
+}
+
+func TestBytesHuman(t *testing.T) {
+	testCases := map[uint64]string{
+		0:               "0B",
+		1023:            "1023B",
+		1536:            "1.50K",
+		5 * 1024 * 1024: "5.00M",
+		3 << 40:         "3.00T",
+		4096 << 40:      "4096.00T",
+	}
+	for n, expected := range testCases {
+		if got := bytesHuman(n); got != expected {
+			t.Errorf("bytesHuman(%d): expected %q, got %q", n, expected, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/response"
+)
+
+// How far a monitor may fall behind before it's disconnected
+const (
+	monitorBacklog      = 4096
+	monitorBacklogBytes = 32 * 1024 * 1024
+)
+
+// monitor is the queue of lines waiting to be written to a MONITOR client
+type monitor struct {
+	lines   chan string
+	pending atomic.Int64 // bytes queued in lines
+}
+
+// MonitorList tracks the clients that ran MONITOR, shared by all
+// connections
+type MonitorList struct {
+	mu       sync.RWMutex
+	monitors map[*client]*monitor
+	// Read without the lock so commands skip formatting when nobody listens
+	count atomic.Int32
+}
+
+func NewMonitorList() *MonitorList {
+	return &MonitorList{monitors: make(map[*client]*monitor)}
+}
+
+func (l *MonitorList) add(c *client) *monitor {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m := &monitor{lines: make(chan string, monitorBacklog)}
+	l.monitors[c] = m
+	l.count.Store(int32(len(l.monitors)))
+	return m
+}
+
+func (l *MonitorList) remove(c *client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.monitors, c)
+	l.count.Store(int32(len(l.monitors)))
+}
+
+func (l *MonitorList) Count() int {
+	return int(l.count.Load())
+}
+
+// feed sends the command run by from to every other monitor. Monitors too
+// slow to keep up are disconnected rather than slowing the server down.
+func (l *MonitorList) feed(from *client, args [][]byte) {
+	if l.count.Load() == 0 {
+		return
+	}
+	line := formatMonitorLine(time.Now(), from.addr(), args)
+
+	var slow []*client
+	l.mu.RLock()
+	for c, m := range l.monitors {
+		if c == from {
+			continue
+		}
+		if m.pending.Add(int64(len(line))) > monitorBacklogBytes {
+			slow = append(slow, c)
+			continue
+		}
+		select {
+		case m.lines <- line:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	l.mu.RUnlock()
+
+	for _, c := range slow {
+		log.Printf("Closing monitor connection from %s: output backlog exceeded", c.addr())
+		l.remove(c)
+		c.kill()
+	}
+}
+
+// formatMonitorLine renders a command the way Redis MONITOR does:
+// +1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+func formatMonitorLine(now time.Time, addr string, args [][]byte) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, addr)
+	for _, arg := range args {
+		builder.WriteString(" ")
+		builder.WriteString(quoteArg(arg))
+	}
+	return response.FormatResponse(response.SimpleStringPrefix, builder.String())
+}
+
+// quoteArg quotes an argument so that binary data and newlines can't
+// break the line
+func quoteArg(arg []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\a':
+			builder.WriteString(`\a`)
+		case '\b':
+			builder.WriteString(`\b`)
+		default:
+			if b < ' ' || b > '~' {
+				fmt.Fprintf(&builder, `\x%02x`, b)
+			} else {
+				builder.WriteByte(b)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+// streamMonitor writes monitor lines to the client until it disconnects,
+// is killed or the server shuts down. Input from the client is discarded.
+func (c *client) streamMonitor(reader *bufio.Reader, writer *bufio.Writer, m *monitor) {
+	defer c.options.Monitors.remove(c)
+
+	disconnected := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(disconnected)
+	}()
+	// The idle timeout doesn't apply to monitors
+	c.conn.SetReadDeadline(time.Time{})
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-disconnected:
+			return
+		case line := <-m.lines:
+			writer.WriteString(line)
+			m.pending.Add(-int64(len(line)))
+			// Batch lines that are already queued into a single write
+			for drained := false; !drained && writer.Buffered() < flushThreshold; {
+				select {
+				case line := <-m.lines:
+					writer.WriteString(line)
+					m.pending.Add(-int64(len(line)))
+				default:
+					drained = true
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				if !c.killed.Load() {
+					log.Printf("Failed to write to monitor: %v", err)
+				}
+				return
+			}
+		}
+	}
+}
+
+func monitorHandler(c *client, args [][]byte) string {
+	c.monitor = c.options.Monitors.add(c)
+	c.monitoring.Store(true)
+	return okResponse()
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	options := DefaultOptions()
+	address, _ := startTestServer(t, options)
+	monitor, monitorReader := dialTestServer(t, address)
+	conn, reader := dialTestServer(t, address)
+
+	if reply := sendCommand(t, monitor, monitorReader, "MONITOR"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	waitForMonitors(t, options.Monitors, 1)
+
+	sendCommand(t, conn, reader, "SET", "key", "a \"quoted\"\nvalue\x00")
+	sendCommand(t, conn, reader, "AUTH", "secret")
+	sendCommand(t, conn, reader, "GET", "key")
+
+	addr := regexp.QuoteMeta(conn.LocalAddr().String())
+	for _, expected := range []string{
+		`^\+\d+\.\d{6} \[0 ` + addr + `\] "SET" "key" "a \\"quoted\\"\\nvalue\\x00"` + "\r\n$",
+		`^\+\d+\.\d{6} \[0 ` + addr + `\] "AUTH" "\(redacted\)"` + "\r\n$",
+		`^\+\d+\.\d{6} \[0 ` + addr + `\] "GET" "key"` + "\r\n$",
+	} {
+		monitor.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := monitorReader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read monitor line: %v", err)
+		}
+		if !regexp.MustCompile(expected).MatchString(line) {
+			t.Fatalf("Expected line matching %q, got %q", expected, line)
+		}
+	}
+
+	reply := sendCommand(t, conn, reader, "CLIENT", "LIST")
+	if !strings.Contains(reply, "id=1 addr="+monitor.LocalAddr().String()) || !strings.Contains(reply, "flags=O ") {
+		t.Fatalf("Expected the monitor to be flagged, got %q", reply)
+	}
+
+	// A monitor doesn't see its own input
+	monitor.Write([]byte("PING\r\n"))
+	monitorReader.ReadString('\n') // CLIENT LIST
+	monitor.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if line, err := monitorReader.ReadString('\n'); err == nil {
+		t.Fatalf("Expected no more lines, got %q", line)
+	}
+
+	monitor.Close()
+	waitForMonitors(t, options.Monitors, 0)
+}
+
+func TestMonitor_SlowClientDisconnected(t *testing.T) {
+	options := DefaultOptions()
+	monitorConn, _ := startTestConnection(t, options)
+	monitorReader := bufio.NewReader(monitorConn)
+	if reply := sendCommand(t, monitorConn, monitorReader, "MONITOR"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+
+	// Nothing reads from the pipe, so lines pile up until the backlog is full
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	other := &client{conn: serverConn, options: options}
+	args := [][]byte{[]byte("SET"), []byte("key"), []byte("value")}
+	// Some lines are already in the connection's write buffer
+	for i := 0; i < 10*monitorBacklog && options.Monitors.Count() > 0; i++ {
+		options.Monitors.feed(other, args)
+	}
+	if count := options.Monitors.Count(); count != 0 {
+		t.Fatalf("Expected the slow monitor to be removed, got %d monitors", count)
+	}
+
+	monitorConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.Copy(io.Discard, monitorReader); err != nil {
+		t.Fatalf("Expected the monitor connection to be closed, got %v", err)
+	}
+}
+
+func TestMonitor_NoMonitors(t *testing.T) {
+	options := DefaultOptions()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	c := &client{conn: serverConn, options: options}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		options.Monitors.feed(c, [][]byte{[]byte("GET"), []byte("key")})
+	})
+	if allocs != 0 {
+		t.Fatalf("Expected no allocations without monitors, got %v", allocs)
+	}
+}
+
+func TestQuoteArg(t *testing.T) {
+	testCases := map[string]string{
+		"":             `""`,
+		"plain":        `"plain"`,
+		"with space":   `"with space"`,
+		`back\slash`:   `"back\\slash"`,
+		"\r\n\t\a\b":   `"\r\n\t\a\b"`,
+		"\x01\x7f\xff": `"\x01\x7f\xff"`,
+		"caf\xc3\xa9":  `"caf\xc3\xa9"`,
+		`"quoted"`:     `"\"quoted\""`,
+	}
+	for input, expected := range testCases {
+		if got := quoteArg([]byte(input)); got != expected {
+			t.Errorf("quoteArg(%q): expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func waitForMonitors(t *testing.T, monitors *MonitorList, count int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for monitors.Count() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d monitors, got %d", count, monitors.Count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/response"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// RewriteAOF compacts the AOF down to the current contents of storage,
+// either as commands or as a snapshot preamble
+func RewriteAOF(aof *persistence.AOF, storage *store.Storage) error {
+	preamble := aof.SnapshotPreamble()
+	annotate := aof.Annotations()
+	return aof.Rewrite(func() func(w io.Writer) error {
+		snapshot := storage.Snapshot()
+		createdAt := time.Now()
+		return func(w io.Writer) error {
+			if preamble {
+				return persistence.WriteSnapshot(w, persistence.SnapshotHeader{CreatedAt: createdAt}, snapshot)
+			}
+			var at time.Time
+			if annotate {
+				at = createdAt
+			}
+			return writeRewriteCommands(w, snapshot, at)
+		}
+	})
+}
+
+// writeRewriteCommands writes a SET or RPUSHes per key, followed by an
+// EXPIREAT for keys with a TTL. Unless at is zero every command is annotated
+// with it.
+func writeRewriteCommands(w io.Writer, snapshot map[string]store.Value, at time.Time) error {
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writer := bufio.NewWriter(w)
+	write := func(args ...[]byte) {
+		command := protocol.EncodeCommand(args)
+		if !at.IsZero() {
+			command = persistence.Annotate(command, at)
+		}
+		writer.Write(command)
+	}
+	for _, key := range keys {
+		value := snapshot[key]
+		switch value.Kind {
+		case store.StringType:
+			write([]byte("SET"), []byte(key), value.Str)
+		case store.ListType:
+			// RPUSH takes a single element
+			for _, element := range value.List {
+				write([]byte("RPUSH"), []byte(key), element)
+			}
+		}
+		if !value.ExpiresAt.IsZero() {
+			// Rounded up, a key mustn't expire any earlier after a reload
+			expiresAt := value.ExpiresAt.Add(time.Second - 1).Unix()
+			write([]byte("EXPIREAT"), []byte(key), []byte(strconv.FormatInt(expiresAt, 10)))
+		}
+	}
+	return writer.Flush()
+}
+
+func bgrewriteaofHandler(c *client, args [][]byte) string {
+	if c.aof == nil {
+		return response.FormatError(response.NewError(response.CodeErr, "AOF is disabled"))
+	}
+	if c.aof.RewriteStatus().InProgress {
+		return response.FormatError(response.NewError(response.CodeErr, persistence.ErrRewriteInProgress.Error()))
+	}
+	go func() {
+		if err := RewriteAOF(c.aof, c.storage); err != nil {
+			log.Printf("Background AOF rewrite failed: %v", err)
+			return
+		}
+		log.Printf("Background AOF rewrite finished successfully")
+	}()
+	return response.FormatResponse(response.SimpleStringPrefix, "Background append only file rewriting started")
+}
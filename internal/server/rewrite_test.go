@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/replay"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+// replayFile loads an AOF the way the server does on startup
+func replayFile(t *testing.T, filename string) *store.Storage {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	defer file.Close()
+
+	storage := store.NewStorage()
+	reader := replay.NewReader(file)
+	if _, err := reader.ReadPreamble(storage.Restore); err != nil {
+		t.Fatalf("Failed to read AOF: %v", err)
+	}
+	if err := reader.Apply(storage); err != nil {
+		t.Fatalf("Failed to read AOF: %v", err)
+	}
+	return storage
+}
+
+func TestBGRewriteAOF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	defer aof.Close()
+	storage := store.NewStorage()
+
+	serverConn, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), serverConn, storage, aof, DefaultOptions())
+		close(done)
+	}()
+	defer func() {
+		conn.Close()
+		<-done
+	}()
+	reader := bufio.NewReader(conn)
+
+	for _, command := range [][]string{
+		{"SET", "greeting", "hello"},
+		{"SET", "greeting", "hi"},
+		{"RPUSH", "chat", "first"},
+		{"RPUSH", "chat", "second"},
+		{"LPUSH", "chat", "zeroth"},
+		{"SETEX", "session", "100", "token"},
+		{"SET", "gone", "value"},
+		{"DEL", "gone"},
+	} {
+		sendCommand(t, conn, reader, command...)
+	}
+	before, _ := aof.Size()
+
+	if reply := sendCommand(t, conn, reader, "BGREWRITEAOF"); reply != "+Background append only file rewriting started\r\n" {
+		t.Fatalf("Unexpected reply: %q", reply)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status := aof.RewriteStatus(); status.LastAt.IsZero(); status = aof.RewriteStatus() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Rewrite didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := aof.RewriteStatus().LastErr; err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+
+	// Writes after the rewrite go to the new file
+	sendCommand(t, conn, reader, "SET", "after", "rewrite")
+	if after, _ := aof.Size(); after >= before {
+		t.Fatalf("Expected the AOF to shrink from %d bytes, got %d", before, after)
+	}
+	data, _ := os.ReadFile(filename)
+	if strings.Contains(string(data), "gone") {
+		t.Fatalf("Expected deleted keys to be dropped, got %q", data)
+	}
+
+	replayed := replayFile(t, filename)
+	expected, got := storage.Snapshot(), replayed.Snapshot()
+	for key, value := range expected {
+		// EXPIREAT has second precision, rounded up
+		if !value.ExpiresAt.IsZero() {
+			value.ExpiresAt = value.ExpiresAt.Add(time.Second - 1).Truncate(time.Second)
+		}
+		expected[key] = value
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("Replayed storage differs:\nexpected %v\ngot      %v", expected, got)
+	}
+
+	reply := sendCommand(t, conn, reader, "INFO", "persistence")
+	for _, field := range []string{"aof_rewrite_in_progress:0\r\n", "aof_last_bgrewrite_status:ok\r\n", "aof_base_size:"} {
+		if !strings.Contains(reply, field) {
+			t.Errorf("Expected %q in INFO output:\n%s", field, reply)
+		}
+	}
+}
+
+func TestBGRewriteAOF_Disabled(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	if reply := sendCommand(t, conn, reader, "BGREWRITEAOF"); reply != "-ERR AOF is disabled\r\n" {
+		t.Fatalf("Unexpected reply: %q", reply)
+	}
+}
+
+func TestRewriteAOF_Annotations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	defer aof.Close()
+	aof.SetAnnotations(true)
+	storage := store.NewStorage()
+	storage.Set("greeting", []byte("hello"))
+	storage.RPush("chat", []byte("first"))
+
+	if err := RewriteAOF(aof, storage); err != nil {
+		t.Fatalf("RewriteAOF failed: %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+	if count := strings.Count(string(data), "#TS:"); count != 2 {
+		t.Fatalf("Expected 2 annotated entries, got %d in %q", count, data)
+	}
+	if !reflect.DeepEqual(storage.Snapshot(), replayFile(t, filename).Snapshot()) {
+		t.Fatal("Replayed storage differs")
+	}
+}
+
+func TestRewriteAOF_SubSecondExpiry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOF(filename)
+	defer aof.Close()
+	storage := store.NewStorage()
+	storage.Set("session", []byte("token"))
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second).Add(500 * time.Millisecond)
+	storage.ExpireAt("session", expiresAt)
+
+	if err := RewriteAOF(aof, storage); err != nil {
+		t.Fatalf("RewriteAOF failed: %v", err)
+	}
+	reloaded := replayFile(t, filename).Snapshot()["session"].ExpiresAt
+	if reloaded.Before(expiresAt) || reloaded.Sub(expiresAt) >= time.Second {
+		t.Fatalf("Expected the expiry %v rounded up to the second, got %v", expiresAt, reloaded)
+	}
+}
@@ -2,39 +2,270 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync/atomic"
+	"time"
 
+	"github.com/flash10042/kv-chat/internal/acl"
 	"github.com/flash10042/kv-chat/internal/persistence"
 	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/response"
+	"github.com/flash10042/kv-chat/internal/slowlog"
 	"github.com/flash10042/kv-chat/internal/store"
 )
 
-func HandleConnection(conn net.Conn, storage *store.Storage, aof *persistence.AOF) {
+// Replies to pipelined commands are batched until the input is drained or
+// this many bytes are pending
+const flushThreshold = 64 * 1024
+
+type Options struct {
+	Limits protocol.Limits
+	ACL    *acl.ACL
+	// Where ACL changes are saved, empty keeps them in memory only
+	ACLFile string
+
+	Clients  *ClientList
+	Monitors *MonitorList
+	Stats    *Stats
+	SlowLog  *slowlog.Log
+	// Used by SAVE and BGSAVE, nil when snapshots are disabled
+	Snapshots *Snapshots
+	// Reported by INFO
+	Version string
+	// Connections past this many are refused, 0 means no limit
+	MaxClients int
+	// Clients idle for longer are disconnected, 0 means never
+	IdleTimeout time.Duration
+	// Clients that stop reading while more than this many bytes of
+	// replies are unsent are disconnected, 0 means no limit
+	OutputBufferLimit int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Limits:     protocol.DefaultLimits,
+		ACL:        acl.New(""),
+		Clients:    NewClientList(),
+		Monitors:   NewMonitorList(),
+		Stats:      NewStats(),
+		SlowLog:    slowlog.New(slowlog.DefaultThreshold, slowlog.DefaultMaxLen),
+		MaxClients: 10000,
+	}
+}
+
+// HandleConnection serves a client until it disconnects or ctx is done.
+// On shutdown a client waiting for input is closed right away, while a
+// command already being executed still completes and gets its reply.
+func HandleConnection(ctx context.Context, conn net.Conn, storage *store.Storage, aof *persistence.AOF, options Options) {
 	defer conn.Close()
 
 	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	writer := bufio.NewWriterSize(&outputWriter{
+		ctx:         ctx,
+		conn:        conn,
+		limit:       options.OutputBufferLimit,
+		idleTimeout: options.IdleTimeout,
+	}, flushThreshold)
+
+	now := time.Now()
+	client := &client{
+		ctx:             ctx,
+		conn:            conn,
+		storage:         storage,
+		aof:             aof,
+		options:         options,
+		createdAt:       now,
+		authenticated:   options.ACL.DefaultUserNoPass(),
+		user:            acl.DefaultUser,
+		lastInteraction: now,
+	}
+
+	options.Stats.connectionsReceived.Add(1)
+	if !options.Clients.add(client, options.MaxClients) {
+		options.Stats.rejectedConnections.Add(1)
+		log.Printf("Refusing connection from %s: max number of clients reached", conn.RemoteAddr())
+		writer.WriteString(response.FormatError(response.NewError(response.CodeErr, "max number of clients reached")))
+		writer.Flush()
+		return
+	}
+	defer options.Clients.remove(client)
+
+	// Unblock a pending read once the server shuts down
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
 
 	for {
-		args, err := protocol.ReadCommand(reader)
+		if options.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(options.IdleTimeout))
+		}
+		// Checked after the idle deadline is set so it can't replace the
+		// shutdown one
+		if ctx.Err() != nil {
+			client.flush(writer)
+			return
+		}
+
+		args, err := protocol.ReadCommandWithLimits(reader, options.Limits)
 		if err != nil {
-			if err != io.EOF {
+			if client.killed.Load() {
+				return
+			}
+			if ctx.Err() != nil {
+				// Replies to an unflushed pipeline still go out
+				client.flush(writer)
+				return
+			}
+			var protocolErr *protocol.ProtocolError
+			var netErr net.Error
+			if errors.As(err, &protocolErr) {
+				log.Printf("Closing connection from %s: %v", conn.RemoteAddr(), err)
+				writer.WriteString(response.FormatError(response.NewError(response.CodeErr, protocolErr.Error())))
+				client.flush(writer)
+			} else if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("Closing idle connection from %s", conn.RemoteAddr())
+			} else if err != io.EOF {
 				log.Printf("Failed to read command: %v", err)
 			}
 			return
 		}
+		if len(args) == 0 {
+			// Null and empty arrays are skipped without a reply, like Redis
+			// does, but replies pending before them still go out
+			if reader.Buffered() == 0 {
+				if err := client.flush(writer); err != nil {
+					log.Printf("Failed to flush writer: %v", err)
+					return
+				}
+			}
+			continue
+		}
 
-		response := protocol.DispatchCommand(protocol.DispatchModePublic, args, storage, aof)
+		response := client.execute(args)
 
+		// The writer flushes on its own when full, which mustn't happen
+		// before earlier writes are in the AOF
+		if len(response) > writer.Available() {
+			if err := client.flush(writer); err != nil {
+				log.Printf("Failed to flush writer: %v", err)
+				return
+			}
+		}
 		if _, err := writer.WriteString(response); err != nil {
 			log.Printf("Failed to write response: %v", err)
 			return
 		}
-		if err := writer.Flush(); err != nil {
-			log.Printf("Failed to flush writer: %v", err)
+		client.recordBuffers(reader.Buffered(), writer.Buffered())
+		if reader.Buffered() > 0 && writer.Buffered() < flushThreshold && !client.closeAfterReply && client.monitor == nil {
+			continue
+		}
+		if err := client.flush(writer); err != nil {
+			if !client.killed.Load() {
+				log.Printf("Failed to flush writer: %v", err)
+			}
+			return
+		}
+		if client.closeAfterReply {
 			return
 		}
+		if client.monitor != nil {
+			client.streamMonitor(reader, writer, client.monitor)
+			return
+		}
+	}
+}
+
+// How often a write in progress is checked for a peer that isn't reading
+const writeStallInterval = 100 * time.Millisecond
+
+// Replies are written in chunks of this size, so a write that's still
+// making progress can be told from a stalled one
+const writeChunkSize = 16 * 1024
+
+var (
+	errOutputBufferLimit = errors.New("output buffer limit exceeded")
+	errOutputStalled     = errors.New("client stopped reading replies")
+)
+
+// outputWriter writes replies to a connection without blocking forever on
+// a peer that stopped reading. A stalled write is abandoned once more than
+// limit bytes are left unsent, the peer has taken nothing for the idle
+// timeout, or the server shuts down. The connection is closed to do so, as
+// write deadlines can't be used: a TLS connection is broken for good once
+// one fires.
+type outputWriter struct {
+	ctx         context.Context
+	conn        net.Conn
+	limit       int
+	idleTimeout time.Duration
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	var written atomic.Int64
+	abandoned := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go w.watch(len(p), &written, abandoned, done)
+
+	for int(written.Load()) < len(p) {
+		chunk := p[written.Load():]
+		if len(chunk) > writeChunkSize {
+			chunk = chunk[:writeChunkSize]
+		}
+		n, err := w.conn.Write(chunk)
+		written.Add(int64(n))
+		if err != nil {
+			select {
+			case err = <-abandoned:
+			default:
+			}
+			return int(written.Load()), err
+		}
+	}
+	return len(p), nil
+}
+
+// watch closes the connection once a write of size bytes, of which written
+// are sent, should be given up on, until done is closed
+func (w *outputWriter) watch(size int, written *atomic.Int64, abandoned chan<- error, done <-chan struct{}) {
+	ticker := time.NewTicker(writeStallInterval)
+	defer ticker.Stop()
+
+	var sent int64
+	var stalled time.Duration
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		// Finished writes are only waiting for done
+		if n := written.Load(); n != sent || n == int64(size) {
+			sent, stalled = n, 0
+			continue
+		}
+		stalled += writeStallInterval
+
+		var err error
+		unsent := size - int(sent)
+		switch {
+		case w.ctx.Err() != nil:
+			err = w.ctx.Err()
+		case w.limit > 0 && unsent > w.limit:
+			err = fmt.Errorf("%w (%d bytes unsent)", errOutputBufferLimit, unsent)
+		case w.idleTimeout > 0 && stalled >= w.idleTimeout:
+			err = errOutputStalled
+		default:
+			continue
+		}
+		abandoned <- err
+		w.conn.Close()
+		return
 	}
 }
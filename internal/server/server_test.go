@@ -0,0 +1,477 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/protocol"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func startTestConnection(t testing.TB, options Options) (net.Conn, *store.Storage) {
+	serverConn, clientConn := net.Pipe()
+	storage := store.NewStorage()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), serverConn, storage, nil, options)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		clientConn.Close()
+		<-done
+	})
+	return clientConn, storage
+}
+
+// startTestServer serves connections on a loopback TCP listener, so each
+// client gets a distinct address
+func startTestServer(t testing.TB, options Options) (string, *store.Storage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	storage := store.NewStorage()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Go(func() {
+				HandleConnection(ctx, conn, storage, nil, options)
+			})
+		}
+	})
+	t.Cleanup(func() {
+		cancel()
+		listener.Close()
+		wg.Wait()
+	})
+	return listener.Addr().String(), storage
+}
+
+func dialTestServer(t testing.TB, address string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// readReply reads one complete RESP reply and returns it verbatim
+func readReply(t testing.TB, reader *bufio.Reader) string {
+	t.Helper()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if n < 0 {
+			return line
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			t.Fatalf("Failed to read bulk reply: %v", err)
+		}
+		return line + string(buf)
+	case '*':
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		for range max(n, 0) {
+			line += readReply(t, reader)
+		}
+	}
+	return line
+}
+
+// sendCommand writes a command and returns its reply
+func sendCommand(t testing.TB, conn net.Conn, reader *bufio.Reader, args ...string) string {
+	t.Helper()
+	encoded := make([][]byte, len(args))
+	for i, arg := range args {
+		encoded[i] = []byte(arg)
+	}
+	if _, err := conn.Write(protocol.EncodeCommand(encoded)); err != nil {
+		t.Fatalf("Failed to write command: %v", err)
+	}
+	return readReply(t, reader)
+}
+
+func TestHandleConnection_Pipelined(t *testing.T) {
+	conn, storage := startTestConnection(t, DefaultOptions())
+
+	var pipeline []byte
+	for i := range 100 {
+		pipeline = append(pipeline, protocol.EncodeCommand([][]byte{
+			[]byte("RPUSH"), []byte("history"), []byte(fmt.Sprintf("message %d", i)),
+		})...)
+	}
+	go conn.Write(pipeline)
+
+	reader := bufio.NewReader(conn)
+	for i := range 100 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read response %d: %v", i, err)
+		}
+		expected := fmt.Sprintf(":%d\r\n", i+1)
+		if line != expected {
+			t.Fatalf("Expected %q, got %q", expected, line)
+		}
+	}
+
+	values, err := storage.LRange("history", 0, -1)
+	if err != nil {
+		t.Fatalf("LRange failed: %v", err)
+	}
+	if len(values) != 100 {
+		t.Fatalf("Expected 100 values, got %d", len(values))
+	}
+}
+
+func TestHandleConnection_AOFBeforeReply(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.aof")
+	aof := persistence.NewAOFWithPolicy(filename, persistence.FsyncAlways)
+	defer aof.Close()
+
+	serverConn, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), serverConn, store.NewStorage(), aof, DefaultOptions())
+		close(done)
+	}()
+	defer func() {
+		conn.Close()
+		<-done
+	}()
+
+	// More replies than fit in the write buffer, so some go out mid-pipeline
+	const commands = 2000
+	var pipeline []byte
+	for i := range commands {
+		pipeline = append(pipeline, protocol.EncodeCommand([][]byte{
+			[]byte("SET"), []byte(fmt.Sprintf("key%d", i)), []byte("value"),
+		})...)
+	}
+	go conn.Write(pipeline)
+
+	reader := bufio.NewReader(conn)
+	for i := range commands {
+		if line, err := reader.ReadString('\n'); err != nil || line != "+OK\r\n" {
+			t.Fatalf("Reply %d: expected OK, got %q, %v", i, line, err)
+		}
+		if i%250 != 0 && i != commands-1 {
+			continue
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to read AOF: %v", err)
+		}
+		if written := strings.Count(string(data), "$3\r\nSET\r\n"); written <= i {
+			t.Fatalf("Reply %d was sent with only %d commands in the AOF", i, written)
+		}
+	}
+}
+
+func TestHandleConnection_AOFClosed(t *testing.T) {
+	aof := persistence.NewAOF(filepath.Join(t.TempDir(), "test.aof"))
+	aof.Close()
+
+	serverConn, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), serverConn, store.NewStorage(), aof, DefaultOptions())
+		close(done)
+	}()
+	defer func() {
+		conn.Close()
+		<-done
+	}()
+
+	reader := bufio.NewReader(conn)
+	if reply := sendCommand(t, conn, reader, "SET", "key", "value"); !strings.HasPrefix(reply, "-MISCONF Errors writing to the AOF file") {
+		t.Fatalf("Expected MISCONF, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "GET", "key"); reply != "$-1\r\n" {
+		t.Fatalf("Expected the rejected write not to be applied, got %q", reply)
+	}
+}
+
+func TestHandleConnection_NullArray(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	conn.Write([]byte("*-1\r\n*0\r\n"))
+	if reply := sendCommand(t, conn, reader, "PING"); reply != "+PONG\r\n" {
+		t.Fatalf("Expected null and empty arrays to get no reply, got %q", reply)
+	}
+
+	// The reply to the command before it isn't held back
+	conn.Write([]byte("*1\r\n$4\r\nPING\r\n*-1\r\n"))
+	if reply := readReply(t, reader); reply != "+PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+}
+
+func TestHandleConnection_ProtocolError(t *testing.T) {
+	for _, input := range []string{
+		"*1\r\n$2000000000\r\n",
+		"*1\r\nX3\r\nfoo\r\n",
+		"*1\r\n$3\r\nfoo\n\n",
+	} {
+		conn, _ := startTestConnection(t, DefaultOptions())
+
+		go conn.Write([]byte(input))
+
+		reader := bufio.NewReader(conn)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read response to %q: %v", input, err)
+		}
+		if !strings.HasPrefix(line, "-ERR Protocol error") {
+			t.Fatalf("Expected protocol error for %q, got %q", input, line)
+		}
+		if _, err := reader.ReadByte(); err == nil {
+			t.Fatalf("Expected connection to be closed after protocol error for %q", input)
+		}
+	}
+}
+
+func BenchmarkHandleConnection_Pipelined(b *testing.B) {
+	const pipelineSize = 1000
+
+	conn, _ := startTestConnection(b, DefaultOptions())
+
+	var pipeline []byte
+	for range pipelineSize {
+		pipeline = append(pipeline, protocol.EncodeCommand([][]byte{
+			[]byte("SET"), []byte("key"), []byte("value"),
+		})...)
+	}
+	reply := []byte("+OK\r\n")
+	buf := make([]byte, len(reply)*pipelineSize)
+
+	b.SetBytes(int64(len(pipeline)))
+	b.ResetTimer()
+	for b.Loop() {
+		go conn.Write(pipeline)
+		read := 0
+		for read < len(buf) {
+			n, err := conn.Read(buf[read:])
+			if err != nil {
+				b.Fatalf("Failed to read responses: %v", err)
+			}
+			read += n
+		}
+	}
+}
+
+func TestHandleConnection_MaxClients(t *testing.T) {
+	options := DefaultOptions()
+	options.MaxClients = 1
+
+	first, _ := startTestConnection(t, options)
+	firstReader := bufio.NewReader(first)
+	if reply := sendCommand(t, first, firstReader, "PING"); reply != "+PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+
+	second, _ := startTestConnection(t, options)
+	reply, err := bufio.NewReader(second).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if reply != "-ERR max number of clients reached\r\n" {
+		t.Fatalf("Expected max clients error, got %q", reply)
+	}
+	if options.Clients.Count() != 1 {
+		t.Fatalf("Expected 1 client, got %d", options.Clients.Count())
+	}
+}
+
+func TestHandleConnection_IdleTimeout(t *testing.T) {
+	options := DefaultOptions()
+	options.IdleTimeout = 50 * time.Millisecond
+
+	conn, _ := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+	if reply := sendCommand(t, conn, reader, "PING"); reply != "+PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected idle connection to be closed, got %v", err)
+	}
+}
+
+func TestHandleConnection_OutputBufferLimit(t *testing.T) {
+	options := DefaultOptions()
+	options.OutputBufferLimit = 64
+
+	conn, storage := startTestConnection(t, options)
+	storage.Set("large", []byte(strings.Repeat("a", 100)))
+
+	// A reply over the limit is fine as long as the client reads it
+	reader := bufio.NewReader(conn)
+	if reply := sendCommand(t, conn, reader, "GET", "large"); reply != "$100\r\n"+strings.Repeat("a", 100)+"\r\n" {
+		t.Fatalf("Expected the large value, got %q", reply)
+	}
+}
+
+func TestHandleConnection_OutputBufferLimitNotReading(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	options := DefaultOptions()
+	options.OutputBufferLimit = 64
+	storage := store.NewStorage()
+	storage.Set("small", []byte("value"))
+	storage.Set("large", []byte(strings.Repeat("a", 100)))
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), serverConn, storage, nil, options)
+		close(done)
+	}()
+
+	// The pipe holds nothing, so the replies stay unsent until read
+	clientConn.Write(protocol.EncodeCommand([][]byte{[]byte("GET"), []byte("small")}))
+	select {
+	case <-done:
+		t.Fatal("Connection with a reply under the limit pending should stay open")
+	case <-time.After(3 * writeStallInterval):
+	}
+	reader := bufio.NewReader(clientConn)
+	if reply := readReply(t, reader); reply != "$5\r\nvalue\r\n" {
+		t.Fatalf("Expected value, got %q", reply)
+	}
+
+	clientConn.Write(protocol.EncodeCommand([][]byte{[]byte("GET"), []byte("large")}))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Connection that stopped reading past the limit should be closed")
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected connection to be closed, got %v", err)
+	}
+}
+
+// testTLSConfig returns a server config with a self-signed certificate
+func testTLSConfig(t testing.TB) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestHandleConnection_TLSPausedReader(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	storage := store.NewStorage()
+	value := strings.Repeat("a", 100*1024)
+	storage.Set("large", []byte(value))
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(context.Background(), tls.Server(serverConn, testTLSConfig(t)), storage, nil, DefaultOptions())
+		close(done)
+	}()
+	conn := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+
+	// The reply stalls for several checks before the client reads it
+	conn.Write(protocol.EncodeCommand([][]byte{[]byte("GET"), []byte("large")}))
+	time.Sleep(5 * writeStallInterval)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	if reply := readReply(t, reader); reply != fmt.Sprintf("$%d\r\n%s\r\n", len(value), value) {
+		t.Fatalf("Expected the whole value, got %d bytes", len(reply))
+	}
+	if reply := sendCommand(t, conn, reader, "PING"); reply != "+PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+}
+
+func TestHandleConnection_ShutdownNotReading(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(ctx, serverConn, store.NewStorage(), nil, DefaultOptions())
+		close(done)
+	}()
+
+	// No limit is set, so only shutdown ends the stalled write
+	clientConn.Write(protocol.EncodeCommand([][]byte{[]byte("PING")}))
+	time.Sleep(writeStallInterval)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Connection stuck writing should be closed promptly on shutdown")
+	}
+}
+
+func TestHandleConnection_Shutdown(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		HandleConnection(ctx, serverConn, store.NewStorage(), nil, DefaultOptions())
+		close(done)
+	}()
+
+	reader := bufio.NewReader(clientConn)
+	if reply := sendCommand(t, clientConn, reader, "PING"); reply != "+PONG\r\n" {
+		t.Fatalf("Expected PONG, got %q", reply)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Idle connection should be closed promptly on shutdown")
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/flash10042/kv-chat/internal/response"
+)
+
+var slowlogSubcommands = map[string]serverCommand{
+	"GET":   {Name: "GET", Arity: -2, Handler: slowlogGetHandler, Categories: []string{"admin", "dangerous"}},
+	"LEN":   {Name: "LEN", Arity: 2, Handler: slowlogLenHandler, Categories: []string{"admin", "dangerous"}},
+	"RESET": {Name: "RESET", Arity: 2, Handler: slowlogResetHandler, Categories: []string{"admin", "dangerous"}},
+}
+
+const defaultSlowlogGetCount = 10
+
+// SLOWLOG GET [count], count -1 returns every entry
+func slowlogGetHandler(c *client, args [][]byte) string {
+	count := defaultSlowlogGetCount
+	switch len(args) {
+	case 2:
+	case 3:
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil || n < -1 {
+			return response.FormatError(response.NewError(response.CodeErr,
+				"count should be greater than or equal to -1"))
+		}
+		count = n
+	default:
+		return response.ErrSyntaxResponse()
+	}
+
+	entries := c.options.SlowLog.Get(count)
+	formatted := make([]string, len(entries))
+	for i, entry := range entries {
+		formatted[i] = response.FormatNestedArray([]string{
+			response.FormatResponse(response.IntegerPrefix, strconv.FormatInt(entry.ID, 10)),
+			response.FormatResponse(response.IntegerPrefix, strconv.FormatInt(entry.Time.Unix(), 10)),
+			response.FormatResponse(response.IntegerPrefix, strconv.FormatInt(entry.Duration.Microseconds(), 10)),
+			response.FormatArray(entry.Args),
+			response.FormatBulkString([]byte(entry.Client.Addr)),
+			response.FormatBulkString([]byte(entry.Client.Name)),
+		})
+	}
+	return response.FormatNestedArray(formatted)
+}
+
+func slowlogLenHandler(c *client, args [][]byte) string {
+	return response.FormatResponse(response.IntegerPrefix, strconv.Itoa(c.options.SlowLog.Len()))
+}
+
+func slowlogResetHandler(c *client, args [][]byte) string {
+	c.options.SlowLog.Reset()
+	return okResponse()
+}
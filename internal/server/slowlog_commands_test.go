@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/flash10042/kv-chat/internal/slowlog"
+)
+
+func TestSlowlog(t *testing.T) {
+	options := DefaultOptions()
+	// Log every command
+	options.SlowLog = slowlog.New(0, 10)
+	conn, _ := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "CLIENT", "SETNAME", "importer")
+	sendCommand(t, conn, reader, "SET", "key", "value")
+	sendCommand(t, conn, reader, "GET", "key")
+	sendCommand(t, conn, reader, "GET", "a", "b")
+
+	// Server commands and rejected commands aren't logged
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "LEN"); reply != ":2\r\n" {
+		t.Fatalf("Expected 2 entries, got %q", reply)
+	}
+
+	reply := sendCommand(t, conn, reader, "SLOWLOG", "GET")
+	if !strings.HasPrefix(reply, "*2\r\n*6\r\n:1\r\n:") {
+		t.Fatalf("Expected the newest entry first, got %q", reply)
+	}
+	for _, expected := range []string{
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n",
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n",
+		"$4\r\npipe\r\n$8\r\nimporter\r\n",
+	} {
+		if !strings.Contains(reply, expected) {
+			t.Errorf("Expected %q in %q", expected, reply)
+		}
+	}
+
+	reply = sendCommand(t, conn, reader, "SLOWLOG", "GET", "1")
+	if !strings.HasPrefix(reply, "*1\r\n*6\r\n:1\r\n") {
+		t.Fatalf("Expected only the newest entry, got %q", reply)
+	}
+
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "RESET"); reply != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "GET", "-1"); reply != "*0\r\n" {
+		t.Fatalf("Expected no entries, got %q", reply)
+	}
+}
+
+func TestSlowlog_Threshold(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "SET", "key", "value")
+	if reply := sendCommand(t, conn, reader, "SLOWLOG", "LEN"); reply != ":0\r\n" {
+		t.Fatalf("Fast commands should not be logged, got %q", reply)
+	}
+}
+
+func TestSlowlog_Errors(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"SLOWLOG"}, "-ERR wrong number of arguments for 'slowlog' command"},
+		{[]string{"SLOWLOG", "NOPE"}, "-ERR unknown subcommand 'NOPE' for 'slowlog'"},
+		{[]string{"SLOWLOG", "GET", "-2"}, "-ERR count should be greater than or equal to -1"},
+		{[]string{"SLOWLOG", "GET", "x"}, "-ERR count should be greater than or equal to -1"},
+		{[]string{"SLOWLOG", "GET", "1", "2"}, "-ERR syntax error"},
+		{[]string{"SLOWLOG", "LEN", "1"}, "-ERR wrong number of arguments for 'slowlog|len' command"},
+	}
+	for _, tc := range testCases {
+		if reply := sendCommand(t, conn, reader, tc.args...); reply != tc.expected+"\r\n" {
+			t.Errorf("%v: expected %q, got %q", tc.args, tc.expected, reply)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/response"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+var ErrSaveInProgress = errors.New("Background save already in progress")
+
+// Snapshots saves the storage to a snapshot file, for SAVE, BGSAVE and the
+// save rules. Only one save runs at a time.
+type Snapshots struct {
+	path string
+
+	mu          sync.Mutex
+	inProgress  bool
+	lastSave    time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// Storage changes covered by the last successful save
+	changesAtSave int64
+}
+
+// NewSnapshots saves to path. changes is the storage's change count when
+// it was loaded, so the loaded data doesn't count as unsaved.
+func NewSnapshots(path string, changes int64) *Snapshots {
+	now := time.Now()
+	return &Snapshots{path: path, lastSave: now, lastAttempt: now, changesAtSave: changes}
+}
+
+func (s *Snapshots) Path() string {
+	return s.path
+}
+
+// SnapshotStatus describes the last save, for INFO and the save rules
+type SnapshotStatus struct {
+	InProgress    bool
+	LastSave      time.Time // last successful save, or startup
+	LastAttempt   time.Time
+	LastErr       error
+	ChangesAtSave int64
+}
+
+func (s *Snapshots) Status() SnapshotStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SnapshotStatus{
+		InProgress:    s.inProgress,
+		LastSave:      s.lastSave,
+		LastAttempt:   s.lastAttempt,
+		LastErr:       s.lastErr,
+		ChangesAtSave: s.changesAtSave,
+	}
+}
+
+// Save writes a snapshot of storage and waits for it to be on disk
+func (s *Snapshots) Save(storage *store.Storage, aof *persistence.AOF) error {
+	save, err := s.start(storage, aof)
+	if err != nil {
+		return err
+	}
+	return save()
+}
+
+// BackgroundSave takes a snapshot of storage right away and writes it in
+// the background
+func (s *Snapshots) BackgroundSave(storage *store.Storage, aof *persistence.AOF) error {
+	save, err := s.start(storage, aof)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := save(); err != nil {
+			log.Printf("Background save failed: %v", err)
+			return
+		}
+		log.Printf("Background save finished successfully")
+	}()
+	return nil
+}
+
+// start takes the snapshot and returns the function writing it
+func (s *Snapshots) start(storage *store.Storage, aof *persistence.AOF) (func() error, error) {
+	s.mu.Lock()
+	if s.inProgress {
+		s.mu.Unlock()
+		return nil, ErrSaveInProgress
+	}
+	s.inProgress = true
+	s.lastAttempt = time.Now()
+	s.mu.Unlock()
+
+	var data map[string]store.Value
+	var changes int64
+	header := persistence.SnapshotHeader{CreatedAt: time.Now()}
+	take := func() {
+		data = storage.Snapshot()
+		changes = storage.Changes()
+	}
+	if aof != nil {
+		// Recording where the AOF stands lets startup replay only the
+		// commands that came after the snapshot
+		position, err := aof.Checkpoint(take)
+		if err != nil {
+			s.finish(changes, err)
+			return nil, err
+		}
+		header.AOF = &position
+	} else {
+		take()
+	}
+
+	return func() error {
+		err := persistence.SaveSnapshot(s.path, header, data)
+		s.finish(changes, err)
+		return err
+	}, nil
+}
+
+func (s *Snapshots) finish(changes int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inProgress = false
+	s.lastErr = err
+	if err == nil {
+		s.lastSave = time.Now()
+		s.changesAtSave = changes
+	}
+}
+
+func saveHandler(c *client, args [][]byte) string {
+	if c.options.Snapshots == nil {
+		return response.FormatError(response.NewError(response.CodeErr, "snapshots are disabled"))
+	}
+	if err := c.options.Snapshots.Save(c.storage, c.aof); err != nil {
+		return response.FormatError(response.NewError(response.CodeErr, err.Error()))
+	}
+	return okResponse()
+}
+
+func bgsaveHandler(c *client, args [][]byte) string {
+	if c.options.Snapshots == nil {
+		return response.FormatError(response.NewError(response.CodeErr, "snapshots are disabled"))
+	}
+	if err := c.options.Snapshots.BackgroundSave(c.storage, c.aof); err != nil {
+		return response.FormatError(response.NewError(response.CodeErr, err.Error()))
+	}
+	return response.FormatResponse(response.SimpleStringPrefix, "Background saving started")
+}
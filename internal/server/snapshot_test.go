@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flash10042/kv-chat/internal/persistence"
+	"github.com/flash10042/kv-chat/internal/store"
+)
+
+func loadTestSnapshot(t *testing.T, filename string) (persistence.SnapshotHeader, *store.Storage) {
+	t.Helper()
+	storage := store.NewStorage()
+	header, err := persistence.LoadSnapshot(filename, storage.Restore)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	return header, storage
+}
+
+func TestSave(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.kvs")
+	options := DefaultOptions()
+	options.Snapshots = NewSnapshots(filename, 0)
+	conn, _ := startTestConnection(t, options)
+	reader := bufio.NewReader(conn)
+
+	sendCommand(t, conn, reader, "SET", "greeting", "hello")
+	sendCommand(t, conn, reader, "RPUSH", "chat", "first")
+	sendCommand(t, conn, reader, "SETEX", "session", "100", "token")
+
+	if reply := sendCommand(t, conn, reader, "INFO", "persistence"); !strings.Contains(reply, "rdb_changes_since_last_save:3\r\n") {
+		t.Fatalf("Expected 3 unsaved changes:\n%s", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "SAVE"); reply != "+OK\r\n" {
+		t.Fatalf("Unexpected reply: %q", reply)
+	}
+
+	header, storage := loadTestSnapshot(t, filename)
+	if header.AOF != nil {
+		t.Fatalf("Expected no AOF position without an AOF, got %+v", header.AOF)
+	}
+	if value, _ := storage.Get("greeting"); string(value) != "hello" {
+		t.Fatalf("Expected the string to be saved, got %q", value)
+	}
+	if list, _ := storage.LRange("chat", 0, -1); len(list) != 1 {
+		t.Fatalf("Expected the list to be saved, got %q", list)
+	}
+	if ttl := storage.TTL("session"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("Expected the TTL to be saved, got %d", ttl)
+	}
+
+	reply := sendCommand(t, conn, reader, "INFO", "persistence")
+	for _, field := range []string{"rdb_changes_since_last_save:0\r\n", "rdb_bgsave_in_progress:0\r\n", "rdb_last_bgsave_status:ok\r\n"} {
+		if !strings.Contains(reply, field) {
+			t.Errorf("Expected %q in INFO output:\n%s", field, reply)
+		}
+	}
+}
+
+func TestBGSave(t *testing.T) {
+	dir := t.TempDir()
+	aof := persistence.NewAOF(filepath.Join(dir, "test.aof"))
+	defer aof.Close()
+	snapshots := NewSnapshots(filepath.Join(dir, "dump.kvs"), 0)
+	storage := store.NewStorage()
+	storage.Set("key", []byte("value"))
+
+	if err := snapshots.BackgroundSave(storage, aof); err != nil {
+		t.Fatalf("BackgroundSave failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for snapshots.Status().InProgress {
+		if time.Now().After(deadline) {
+			t.Fatal("Background save didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := snapshots.Status(); status.LastErr != nil || status.ChangesAtSave != 1 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+
+	header, loaded := loadTestSnapshot(t, snapshots.Path())
+	if header.AOF == nil {
+		t.Fatal("Expected the AOF position to be recorded")
+	}
+	if value, _ := loaded.Get("key"); string(value) != "value" {
+		t.Fatalf("Expected the key to be saved, got %q", value)
+	}
+}
+
+func TestSave_Errors(t *testing.T) {
+	conn, _ := startTestConnection(t, DefaultOptions())
+	reader := bufio.NewReader(conn)
+	for _, command := range []string{"SAVE", "BGSAVE"} {
+		if reply := sendCommand(t, conn, reader, command); reply != "-ERR snapshots are disabled\r\n" {
+			t.Fatalf("%s: unexpected reply %q", command, reply)
+		}
+	}
+
+	options := DefaultOptions()
+	options.Snapshots = NewSnapshots(filepath.Join(t.TempDir(), "missing", "dump.kvs"), 0)
+	conn, _ = startTestConnection(t, options)
+	reader = bufio.NewReader(conn)
+	if reply := sendCommand(t, conn, reader, "SAVE"); !strings.HasPrefix(reply, "-ERR ") {
+		t.Fatalf("Expected an error saving to a missing directory, got %q", reply)
+	}
+	if reply := sendCommand(t, conn, reader, "INFO", "persistence"); !strings.Contains(reply, "rdb_last_bgsave_status:err\r\n") {
+		t.Fatalf("Expected the failure in INFO:\n%s", reply)
+	}
+}
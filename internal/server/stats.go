@@ -0,0 +1,35 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds server-wide counters, shared by all connections
+type Stats struct {
+	startTime time.Time
+
+	connectionsReceived atomic.Int64
+	rejectedConnections atomic.Int64
+	commandsProcessed   atomic.Int64
+}
+
+func NewStats() *Stats {
+	return &Stats{startTime: time.Now()}
+}
+
+func (s *Stats) Uptime() time.Duration {
+	return time.Since(s.startTime)
+}
+
+func (s *Stats) ConnectionsReceived() int64 {
+	return s.connectionsReceived.Load()
+}
+
+func (s *Stats) RejectedConnections() int64 {
+	return s.rejectedConnections.Load()
+}
+
+func (s *Stats) CommandsProcessed() int64 {
+	return s.commandsProcessed.Load()
+}
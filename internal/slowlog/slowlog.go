@@ -0,0 +1,120 @@
+// Package slowlog keeps the most recent commands that took longer than a
+// threshold to execute
+package slowlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultThreshold = 10 * time.Millisecond
+	DefaultMaxLen    = 128
+
+	// Arguments are truncated so a huge RPUSH doesn't pin its payload in
+	// memory
+	maxArgs      = 32
+	maxArgLength = 128
+)
+
+// Client identifies the connection that ran a command
+type Client struct {
+	Addr string
+	Name string
+}
+
+type Entry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	Args     [][]byte
+	Client   Client
+}
+
+// Log is a bounded ring of slow entries, safe for concurrent use
+type Log struct {
+	mu        sync.Mutex
+	threshold time.Duration // negative disables logging
+	maxLen    int
+	entries   []Entry // oldest first
+	nextID    int64
+}
+
+func New(threshold time.Duration, maxLen int) *Log {
+	return &Log{threshold: threshold, maxLen: maxLen}
+}
+
+// Record adds the command if it ran for at least the threshold
+func (l *Log) Record(args [][]byte, duration time.Duration, client Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.threshold < 0 || duration < l.threshold || l.maxLen <= 0 {
+		return
+	}
+
+	entry := Entry{
+		ID:       l.nextID,
+		Time:     time.Now(),
+		Duration: duration,
+		Args:     truncateArgs(args),
+		Client:   client,
+	}
+	l.nextID++
+
+	if len(l.entries) >= l.maxLen {
+		copy(l.entries, l.entries[len(l.entries)-l.maxLen+1:])
+		l.entries = l.entries[:l.maxLen-1]
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// Get returns up to count entries, newest first. A negative count returns
+// all of them.
+func (l *Log) Get(count int) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	entries := make([]Entry, count)
+	for i := range entries {
+		entries[i] = l.entries[len(l.entries)-1-i]
+	}
+	return entries
+}
+
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.entries)
+}
+
+func (l *Log) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+}
+
+func truncateArgs(args [][]byte) [][]byte {
+	count := min(len(args), maxArgs)
+	truncated := make([][]byte, count)
+	for i := range count {
+		// The last slot says how many arguments were left out
+		if i == maxArgs-1 && len(args) > maxArgs {
+			truncated[i] = fmt.Appendf(nil, "... (%d more arguments)", len(args)-maxArgs+1)
+			break
+		}
+		arg := args[i]
+		if len(arg) > maxArgLength {
+			truncated[i] = fmt.Appendf(nil, "%s... (%d more bytes)", arg[:maxArgLength], len(arg)-maxArgLength)
+			continue
+		}
+		truncated[i] = append([]byte{}, arg...)
+	}
+	return truncated
+}
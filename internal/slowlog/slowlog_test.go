@@ -0,0 +1,114 @@
+package slowlog
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func args(values ...string) [][]byte {
+	result := make([][]byte, len(values))
+	for i, v := range values {
+		result[i] = []byte(v)
+	}
+	return result
+}
+
+func TestRecord_Threshold(t *testing.T) {
+	log := New(time.Millisecond, 10)
+	log.Record(args("GET", "fast"), time.Microsecond, Client{})
+	log.Record(args("LRANGE", "slow", "0", "-1"), 5*time.Millisecond, Client{Addr: "127.0.0.1:5000", Name: "api"})
+
+	if log.Len() != 1 {
+		t.Fatalf("Expected 1 entry, got %d", log.Len())
+	}
+	entry := log.Get(-1)[0]
+	if entry.ID != 0 || entry.Duration != 5*time.Millisecond || string(entry.Args[0]) != "LRANGE" {
+		t.Fatalf("Unexpected entry %+v", entry)
+	}
+	if entry.Client.Addr != "127.0.0.1:5000" || entry.Client.Name != "api" {
+		t.Fatalf("Unexpected client %+v", entry.Client)
+	}
+	if time.Since(entry.Time) > time.Second {
+		t.Fatalf("Unexpected timestamp %v", entry.Time)
+	}
+}
+
+func TestRecord_Disabled(t *testing.T) {
+	log := New(-1, 10)
+	log.Record(args("GET", "key"), time.Hour, Client{})
+	if log.Len() != 0 {
+		t.Fatalf("Expected no entries, got %d", log.Len())
+	}
+
+	// Zero logs every command
+	log = New(0, 10)
+	log.Record(args("GET", "key"), 0, Client{})
+	if log.Len() != 1 {
+		t.Fatalf("Expected 1 entry, got %d", log.Len())
+	}
+}
+
+func TestRecord_MaxLen(t *testing.T) {
+	log := New(0, 3)
+	for i := range 5 {
+		log.Record(args("SET", fmt.Sprint(i), "v"), time.Millisecond, Client{})
+	}
+
+	entries := log.Get(-1)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	// Newest first, ids keep increasing after old entries are dropped
+	for i, id := range []int64{4, 3, 2} {
+		if entries[i].ID != id || string(entries[i].Args[1]) != fmt.Sprint(id) {
+			t.Fatalf("Entry %d: expected id %d, got %+v", i, id, entries[i])
+		}
+	}
+
+	if entries := log.Get(2); len(entries) != 2 || entries[0].ID != 4 {
+		t.Fatalf("Expected the 2 newest entries, got %+v", entries)
+	}
+
+	log.Reset()
+	if log.Len() != 0 {
+		t.Fatalf("Expected no entries after reset, got %d", log.Len())
+	}
+	log.Record(args("PING"), time.Millisecond, Client{})
+	if entry := log.Get(1)[0]; entry.ID != 5 {
+		t.Fatalf("Expected ids to continue after reset, got %d", entry.ID)
+	}
+}
+
+func TestRecord_TruncatesArgs(t *testing.T) {
+	log := New(0, 10)
+
+	long := args("RPUSH", "key", string(bytes.Repeat([]byte("x"), 200)))
+	log.Record(long, time.Millisecond, Client{})
+	expected := string(bytes.Repeat([]byte("x"), 128)) + "... (72 more bytes)"
+	if got := string(log.Get(1)[0].Args[2]); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+
+	many := make([][]byte, 40)
+	for i := range many {
+		many[i] = []byte(fmt.Sprint(i))
+	}
+	log.Record(many, time.Millisecond, Client{})
+	recorded := log.Get(1)[0].Args
+	if len(recorded) != 32 {
+		t.Fatalf("Expected 32 arguments, got %d", len(recorded))
+	}
+	if string(recorded[30]) != "30" || string(recorded[31]) != "... (9 more arguments)" {
+		t.Fatalf("Unexpected tail %q %q", recorded[30], recorded[31])
+	}
+
+	// The recorded args don't alias the command buffer
+	short := args("GET", "key")
+	log.Record(short, time.Millisecond, Client{})
+	short[1][0] = 'X'
+	if string(log.Get(1)[0].Args[1]) != "key" {
+		t.Fatal("Recorded args should be copied")
+	}
+}
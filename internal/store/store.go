@@ -33,6 +33,29 @@ func (value Value) IsExpired() bool {
 type Storage struct {
 	mu   sync.Mutex
 	data map[string]Value
+
+	// Guarded by mu
+	// Keys with a TTL, kept up to date by put and remove
+	expires        int
+	keyspaceHits   int64
+	keyspaceMisses int64
+	expiredKeys    int64
+	// Writes since the storage was created, for the snapshot save rules
+	changes int64
+}
+
+// Stats is a point-in-time view of the keyspace counters
+type Stats struct {
+	Keys    int
+	Expires int // keys with a TTL
+	// Lookups by read commands that found or missed the key
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+	// Keys removed because their TTL passed
+	ExpiredKeys int64
+	// Keys removed to free memory. There's no maxmemory policy yet, so
+	// nothing is ever evicted.
+	EvictedKeys int64
 }
 
 func NewStorage() *Storage {
@@ -48,18 +71,19 @@ func (s *Storage) Set(key string, value []byte) {
 	valueCopy := make([]byte, len(value))
 	copy(valueCopy, value)
 
-	s.data[key] = Value{
+	s.put(key, Value{
 		Kind:      StringType,
 		Str:       valueCopy,
 		ExpiresAt: time.Time{},
-	}
+	})
+	s.changes++
 }
 
 func (s *Storage) Get(key string) ([]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	value, ok := s.getIfNotExpired(key)
+	value, ok := s.lookupRead(key)
 	if !ok {
 		return nil, nil
 	}
@@ -82,7 +106,8 @@ func (s *Storage) Del(key string) bool {
 		return false
 	}
 
-	delete(s.data, key)
+	s.remove(key)
+	s.changes++
 	return true
 }
 
@@ -90,7 +115,7 @@ func (s *Storage) Exists(key string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, ok := s.getIfNotExpired(key)
+	_, ok := s.lookupRead(key)
 	return ok
 }
 
@@ -100,18 +125,19 @@ func (s *Storage) SetEx(key string, seconds int64, value []byte) {
 
 	valueCopy := make([]byte, len(value))
 
+	s.changes++
 	if seconds <= 0 {
-		delete(s.data, key)
+		s.remove(key)
 		return
 	}
 
 	expiresAt := time.Now().Add(time.Duration(seconds) * time.Second)
 	copy(valueCopy, value)
-	s.data[key] = Value{
+	s.put(key, Value{
 		Kind:      StringType,
 		Str:       valueCopy,
 		ExpiresAt: expiresAt,
-	}
+	})
 }
 
 func (s *Storage) LPush(key string, value []byte) (int, error) {
@@ -140,7 +166,8 @@ func (s *Storage) LPush(key string, value []byte) (int, error) {
 		storageValue.List = newList
 	}
 
-	s.data[key] = storageValue
+	s.put(key, storageValue)
+	s.changes++
 	return len(storageValue.List), nil
 }
 
@@ -166,7 +193,8 @@ func (s *Storage) RPush(key string, value []byte) (int, error) {
 		storageValue.List = append(storageValue.List, valueCopy)
 	}
 
-	s.data[key] = storageValue
+	s.put(key, storageValue)
+	s.changes++
 	return len(storageValue.List), nil
 }
 
@@ -174,7 +202,7 @@ func (s *Storage) LRange(key string, start, end int) ([][]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	value, ok := s.getIfNotExpired(key)
+	value, ok := s.lookupRead(key)
 	if !ok {
 		return nil, nil
 	} else if value.Kind != ListType {
@@ -213,14 +241,15 @@ func (s *Storage) Expire(key string, seconds int64) bool {
 		return false
 	}
 
+	s.changes++
 	if seconds <= 0 {
-		delete(s.data, key)
+		s.remove(key)
 		return true
 	}
 
 	expiresAt := time.Now().Add(time.Duration(seconds) * time.Second)
 	value.ExpiresAt = expiresAt
-	s.data[key] = value
+	s.put(key, value)
 	return true
 }
 
@@ -228,7 +257,7 @@ func (s *Storage) TTL(key string) int64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	value, ok := s.getIfNotExpired(key)
+	value, ok := s.lookupRead(key)
 	if !ok {
 		return -2
 	}
@@ -240,7 +269,8 @@ func (s *Storage) TTL(key string) int64 {
 	// Edge case: if key expired after getIfNotExpired, we need to delete it
 	remaining := time.Until(value.ExpiresAt)
 	if remaining <= 0 {
-		delete(s.data, key)
+		s.remove(key)
+		s.expiredKeys++
 		return -2
 	}
 
@@ -254,12 +284,95 @@ func (s *Storage) getIfNotExpired(key string) (Value, bool) {
 		return Value{}, false
 	}
 	if v.IsExpired() {
-		delete(s.data, key)
+		s.remove(key)
+		s.expiredKeys++
 		return Value{}, false
 	}
 	return v, true
 }
 
+// lookupRead is getIfNotExpired for read commands, counting keyspace hits
+// and misses
+func (s *Storage) lookupRead(key string) (Value, bool) {
+	// Method should be called with the lock held
+	v, ok := s.getIfNotExpired(key)
+	if ok {
+		s.keyspaceHits++
+	} else {
+		s.keyspaceMisses++
+	}
+	return v, ok
+}
+
+// Snapshot returns the live keys as of now. Values are shared with the
+// storage, which never modifies them in place, so the copy is cheap and
+// stays consistent while the storage keeps changing.
+func (s *Storage) Snapshot() map[string]Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]Value, len(s.data))
+	for key, value := range s.data {
+		if !value.IsExpired() {
+			snapshot[key] = value
+		}
+	}
+	return snapshot
+}
+
+// Restore puts back a value read from a snapshot, unless it has expired
+// since
+func (s *Storage) Restore(key string, value Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value.IsExpired() {
+		return
+	}
+	s.put(key, value)
+}
+
+// put stores a value, keeping the count of keys with a TTL. Called with mu
+// held, as is remove.
+func (s *Storage) put(key string, value Value) {
+	if old, ok := s.data[key]; ok && !old.ExpiresAt.IsZero() {
+		s.expires--
+	}
+	if !value.ExpiresAt.IsZero() {
+		s.expires++
+	}
+	s.data[key] = value
+}
+
+func (s *Storage) remove(key string) {
+	if old, ok := s.data[key]; ok && !old.ExpiresAt.IsZero() {
+		s.expires--
+	}
+	delete(s.data, key)
+}
+
+// Changes returns the number of writes since the storage was created
+func (s *Storage) Changes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.changes
+}
+
+func (s *Storage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Keys:           len(s.data),
+		Expires:        s.expires,
+		KeyspaceHits:   s.keyspaceHits,
+		KeyspaceMisses: s.keyspaceMisses,
+		ExpiredKeys:    s.expiredKeys,
+	}
+	return stats
+}
+
 func (s *Storage) ExpireAt(key string, when time.Time) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -269,13 +382,14 @@ func (s *Storage) ExpireAt(key string, when time.Time) bool {
 		return false
 	}
 
+	s.changes++
 	if when.Before(time.Now()) {
-		delete(s.data, key)
+		s.remove(key)
 		return true
 	}
 
 	value.ExpiresAt = when
-	s.data[key] = value
+	s.put(key, value)
 	return true
 }
 
@@ -285,15 +399,16 @@ func (s *Storage) SetExAt(key string, when time.Time, value []byte) {
 
 	valueCopy := make([]byte, len(value))
 
+	s.changes++
 	if when.Before(time.Now()) {
-		delete(s.data, key)
+		s.remove(key)
 		return
 	}
 
 	copy(valueCopy, value)
-	s.data[key] = Value{
+	s.put(key, Value{
 		Kind:      StringType,
 		Str:       valueCopy,
 		ExpiresAt: when,
-	}
+	})
 }
@@ -603,3 +603,130 @@ func TestValueStruct(t *testing.T) {
 		t.Fatal("Str should match")
 	}
 }
+
+// Test keyspace statistics
+func TestStats(t *testing.T) {
+	storage := NewStorage()
+	storage.Set("key1", []byte("value"))
+	storage.SetEx("key2", 100, []byte("value"))
+	storage.RPush("list", []byte("a"))
+	storage.put("old", Value{Kind: StringType, Str: []byte("x"), ExpiresAt: time.Now().Add(-time.Second)})
+
+	storage.Get("key1")
+	storage.LRange("list", 0, -1)
+	storage.Get("missing")
+	storage.Exists("old")
+
+	stats := storage.Stats()
+	expected := Stats{Keys: 3, Expires: 1, KeyspaceHits: 2, KeyspaceMisses: 2, ExpiredKeys: 1}
+	if stats != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, stats)
+	}
+
+	// Writes don't count as lookups
+	storage.Set("key1", []byte("other"))
+	storage.RPush("list", []byte("b"))
+	if stats := storage.Stats(); stats.KeyspaceHits != 2 || stats.KeyspaceMisses != 2 {
+		t.Fatalf("Writes should not change hits and misses, got %+v", stats)
+	}
+}
+
+// The count of keys with a TTL follows keys gaining and losing one
+func TestStats_Expires(t *testing.T) {
+	storage := NewStorage()
+	expect := func(expires int) {
+		t.Helper()
+		if stats := storage.Stats(); stats.Expires != expires {
+			t.Fatalf("Expected %d keys with a TTL, got %d", expires, stats.Expires)
+		}
+	}
+
+	storage.SetEx("a", 100, []byte("1"))
+	storage.Set("b", []byte("2"))
+	expect(1)
+	storage.Expire("b", 100)
+	storage.Expire("b", 200)
+	expect(2)
+	storage.RPush("list", []byte("x"))
+	storage.ExpireAt("list", time.Now().Add(time.Hour))
+	storage.RPush("list", []byte("y"))
+	expect(3)
+	// Overwriting drops the TTL
+	storage.Set("a", []byte("3"))
+	expect(2)
+	storage.Del("b")
+	expect(1)
+	storage.ExpireAt("list", time.Now().Add(-time.Second))
+	expect(0)
+	storage.SetExAt("c", time.Now().Add(-time.Second), []byte("4"))
+	storage.Restore("d", Value{Kind: StringType, Str: []byte("5"), ExpiresAt: time.Now().Add(time.Hour)})
+	expect(1)
+}
+
+// Test that a snapshot doesn't see later changes
+func TestSnapshot(t *testing.T) {
+	storage := NewStorage()
+	storage.Set("key", []byte("value"))
+	storage.RPush("list", []byte("a"))
+	storage.SetEx("session", 100, []byte("token"))
+	storage.put("old", Value{Kind: StringType, Str: []byte("x"), ExpiresAt: time.Now().Add(-time.Second)})
+
+	snapshot := storage.Snapshot()
+
+	storage.Set("key", []byte("other"))
+	storage.RPush("list", []byte("b"))
+	storage.LPush("list", []byte("c"))
+	storage.Del("session")
+
+	if len(snapshot) != 3 {
+		t.Fatalf("Expected 3 live keys, got %d", len(snapshot))
+	}
+	if string(snapshot["key"].Str) != "value" {
+		t.Fatalf("Expected the old value, got %q", snapshot["key"].Str)
+	}
+	if list := snapshot["list"].List; len(list) != 1 || string(list[0]) != "a" {
+		t.Fatalf("Expected the old list, got %q", list)
+	}
+	if snapshot["session"].ExpiresAt.IsZero() {
+		t.Fatal("Expected the expiration to be kept")
+	}
+}
+
+// Test that writes are counted and reads and failed writes aren't
+func TestChanges(t *testing.T) {
+	storage := NewStorage()
+	storage.Set("key", []byte("value"))
+	storage.RPush("list", []byte("a"))
+	storage.Expire("key", 100)
+	storage.Del("list")
+
+	storage.Get("key")
+	storage.Del("missing")
+	storage.Expire("missing", 100)
+	storage.LPush("key", []byte("a"))
+
+	if changes := storage.Changes(); changes != 4 {
+		t.Fatalf("Expected 4 changes, got %d", changes)
+	}
+}
+
+// Test restoring values from a snapshot
+func TestRestore(t *testing.T) {
+	storage := NewStorage()
+	storage.Restore("list", Value{Kind: ListType, List: [][]byte{[]byte("a"), []byte("b")}})
+	storage.Restore("session", Value{Kind: StringType, Str: []byte("token"), ExpiresAt: time.Now().Add(time.Hour)})
+	storage.Restore("old", Value{Kind: StringType, Str: []byte("x"), ExpiresAt: time.Now().Add(-time.Second)})
+
+	if list, _ := storage.LRange("list", 0, -1); len(list) != 2 {
+		t.Fatalf("Expected the list to be restored, got %q", list)
+	}
+	if ttl := storage.TTL("session"); ttl <= 0 {
+		t.Fatalf("Expected the TTL to be restored, got %d", ttl)
+	}
+	if storage.Exists("old") {
+		t.Fatal("Expected expired values to be skipped")
+	}
+	if changes := storage.Changes(); changes != 0 {
+		t.Fatalf("Expected restoring not to count as changes, got %d", changes)
+	}
+}